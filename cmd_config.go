@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/urfave/cli/v2"
+)
+
+func init() {
+	AddSubCommand(commandConfig, "any")
+}
+
+var commandConfig = &cli.Command{
+	Name:        "config",
+	Usage:       T("config-cmd-usage"),
+	Description: T("config-cmd-describe"),
+	Subcommands: []*cli.Command{
+		{
+			Name:      "validate",
+			Usage:     T("config-validate-usage"),
+			ArgsUsage: "[file ...]",
+			Action:    runConfigValidate,
+		},
+		{
+			Name:      "migrate",
+			Usage:     T("config-migrate-usage"),
+			ArgsUsage: "[file ...]",
+			Flags:     []cli.Flag{&mainFlagDryRun},
+			Action:    runConfigMigrate,
+		},
+	},
+}
+
+// configFile is a loaded gd-tools-system.json or gd-tools-serve.json
+type configFile struct {
+	Path   string
+	Schema int
+	Config interface{ Validate() error }
+}
+
+func runConfigValidate(c *cli.Context) error {
+	paths, err := configPaths(c)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, path := range paths {
+		file, err := configLoad(path)
+		if err == nil {
+			err = file.Config.Validate()
+		}
+		if err != nil {
+			fmt.Printf("%s:\n%v\n", path, err)
+			failed++
+			continue
+		}
+
+		if file.Schema < ConfigSchema {
+			fmt.Println(Tf("config-hint-migrate", path, file.Schema, ConfigSchema))
+		}
+		fmt.Println(Tf("config-valid", path))
+	}
+
+	if failed > 0 {
+		return cli.Exit(Tf("config-invalid", failed), 1)
+	}
+
+	return nil
+}
+
+func runConfigMigrate(c *cli.Context) error {
+	dryRun := c.Bool("dry")
+
+	paths, err := configPaths(c)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		file, err := configLoad(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if file.Schema == ConfigSchema {
+			fmt.Println(Tf("config-migrate-current", path, ConfigSchema))
+			continue
+		}
+
+		content, err := json.MarshalIndent(file.Config, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		// keep the old file next to the new one
+		backup := fmt.Sprintf("%s.schema%d", path, file.Schema)
+		fmt.Println(Tf("config-migrate-write", path, file.Schema, ConfigSchema, backup))
+		if dryRun {
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if err := os.Rename(path, backup); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, content, info.Mode().Perm()); err != nil {
+			return err
+		}
+
+		if err := file.Config.Validate(); err != nil {
+			fmt.Printf("%s:\n%v\n", path, err)
+		}
+	}
+
+	return nil
+}
+
+// configPaths returns the files given on the command line or the default ones
+func configPaths(c *cli.Context) ([]string, error) {
+	if c.NArg() > 0 {
+		return c.Args().Slice(), nil
+	}
+
+	dir := "."
+	if CheckEnv("prod") {
+		dir = "/etc"
+	}
+
+	var paths []string
+	for _, name := range []string{SystemConfigName, ServeConfigName} {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf(Tf("config-err-no-files", dir))
+	}
+
+	return paths, nil
+}
+
+func configLoad(path string) (*configFile, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file := configFile{Path: path}
+	if filepath.Base(path) == ServeConfigName {
+		var config ServeConfig
+		file.Schema, err = ConfigDecode(content, serveMigrations, &config)
+		config.Schema = ConfigSchema
+		file.Config = &config
+	} else {
+		var config SystemConfig
+		file.Schema, err = ConfigDecode(content, systemMigrations, &config)
+		config.Schema = ConfigSchema
+		file.Config = &config
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &file, nil
+}
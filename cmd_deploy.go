@@ -57,7 +57,8 @@ func runDeploy(c *cli.Context) error {
 	if err := DeployLocal(c, SystemConfigName, "/etc", rootUser, "400"); err != nil {
 		return err
 	}
-	if err := DeployLocal(c, ServeConfigName, "/etc", rootUser, "444"); err != nil {
+	// password hashes and the session key: only for root and the serve process
+	if err := DeployLocalOwned(c, ServeConfigName, "/etc", rootUser, "root:gd-tools", "440"); err != nil {
 		return err
 	}
 
@@ -99,7 +100,7 @@ func runDeploy(c *cli.Context) error {
 		},
 		Local:    localPath + "/",
 		Receiver: toolsUser,
-		Remote:   "projects",
+		Remote:   ProjectsDir,
 	}
 	if !c.Bool("debug") {
 		rsyncProjects.Flags = append(rsyncProjects.Flags, "--quiet")
@@ -132,25 +133,6 @@ func runDeploy(c *cli.Context) error {
 		}
 	}
 
-	// Fetch certs from target before overwrite
-	DeployFetchLetsEncrypt(c, rootUser)
-
-	// Push certs if available locally
-	if stat, err := os.Stat("letsencrypt"); err == nil && stat.IsDir() {
-		rsyncCerts := DeployRsync{
-			DryRun:   dryRun,
-			Flags:    []string{"--chown=root:root"},
-			Local:    "letsencrypt/",
-			Receiver: rootUser,
-			Remote:   "/etc/letsencrypt",
-		}
-		if !c.Bool("debug") {
-			rsyncCerts.Flags = append(rsyncCerts.Flags, "--quiet")
-		}
-		if err := rsyncCerts.Execute(); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	// Sync certs in both directions, only pushing what is newer
+	return DeploySyncLetsEncrypt(c, rootUser)
 }
@@ -1,23 +1,30 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 
 	"github.com/urfave/cli/v2"
 )
 
 const (
-	ServeConfigName = "gd-tools-serve.json"
+	ServeConfigName        = "gd-tools-serve.json"
+	ServePasswordMinLength = 10
 )
 
-var serveConfig ServeConfig
-
 func init() {
-	AddSubCommand(commandServe, "prod")
+	AddSubCommand(commandServe, "any")
+}
+
+var serveFlagFile = cli.StringFlag{
+	Name:  "file",
+	Usage: T("serve-flag-file"),
 }
 
 var commandServe = &cli.Command{
@@ -26,37 +33,31 @@ var commandServe = &cli.Command{
 	Description: T("serve-cmd-describe"),
 	Flags:       []cli.Flag{},
 	Action:      runServe,
+	Subcommands: []*cli.Command{
+		commandServePasswd,
+		commandServeUser,
+		commandServeTOTP,
+		commandServeToken,
+	},
 }
 
 func runServe(c *cli.Context) error {
+	// passwd also runs in the development directory, the server does not
+	if !CheckEnv("prod") {
+		return fmt.Errorf(T("serve-only-prod"))
+	}
 	if euid := os.Geteuid(); euid == 0 {
 		msg := T("serve-not-as-root")
 		return fmt.Errorf(msg)
 	}
 
-	content, err := os.ReadFile(filepath.Join("/etc", ServeConfigName))
+	site, err := ServeLoadSite(nil)
 	if err != nil {
 		return err
 	}
-	if err := json.Unmarshal(content, &serveConfig); err != nil {
-		return err
-	}
-
-	if serveConfig.SysAdmin == "" {
-		return fmt.Errorf("missing SysAdmin")
-	}
-	if serveConfig.Address == "" {
-		serveConfig.Address = "127.0.0.1:3000"
-	}
-
-	if serveLayoutContent, err = ServeLoadPage("application.html"); err != nil {
-		return err
-	}
-	if serveHomeContent, err = ServeLoadPage("home.html"); err != nil {
-		return err
-	}
-	if serveStatusContent, err = ServeLoadPage("status.html"); err != nil {
-		return err
+	serveSite.Store(site)
+	if err := ServeStateLoad(); err != nil {
+		return fmt.Errorf("%s: %w", ServeStateName, err)
 	}
 
 	LocaleInit()
@@ -68,14 +69,87 @@ func runServe(c *cli.Context) error {
 }
 
 func (sc ServeConfig) Save() error {
+	return sc.SaveTo(ServeConfigName)
+}
+
+// SaveTo keeps the mode of an existing file, a new one is private (password hashes, keys)
+func (sc ServeConfig) SaveTo(path string) error {
+	sc.Schema = ConfigSchema
 	content, err := json.MarshalIndent(sc, "", "  ")
 	if err != nil {
 		return err
 	}
 
-	if err := os.WriteFile(ServeConfigName, content, 0644); err != nil {
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := os.WriteFile(path, content, mode); err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// serveConfigPath is the file in /etc on the server, otherwise the one in the current directory
+func serveConfigPath(c *cli.Context) string {
+	if path := c.String("file"); path != "" {
+		return path
+	}
+	if CheckEnv("prod") {
+		return filepath.Join("/etc", ServeConfigName)
+	}
+	return ServeConfigName
+}
+
+// serveLoadConfig reads and migrates a serve config for the CLI subcommands
+func serveLoadConfig(path string) (*ServeConfig, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config ServeConfig
+	if _, err := ConfigDecode(content, serveMigrations, &config); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return &config, nil
+}
+
+// serveStdin is shared, a second reader would miss what the first one buffered
+var serveStdin = bufio.NewReader(os.Stdin)
+
+// serveReadPassword turns off the echo while a terminal is attached
+func serveReadPassword(prompt string) (string, error) {
+	fmt.Print(prompt + " ")
+
+	echoOff := exec.Command("stty", "-echo")
+	echoOff.Stdin = os.Stdin
+	if echoOff.Run() == nil {
+		defer func() {
+			echoOn := exec.Command("stty", "echo")
+			echoOn.Stdin = os.Stdin
+			echoOn.Run()
+			fmt.Println()
+		}()
+	}
+
+	line, err := serveStdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func serveReadLine(prompt string) (string, error) {
+	fmt.Print(prompt + " ")
+
+	line, err := serveStdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+
+	return strings.TrimSpace(line), nil
+}
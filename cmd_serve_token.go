@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+var serveFlagScope = cli.StringFlag{
+	Name:  "scope",
+	Usage: T("serve-flag-scope"),
+	Value: ScopeRead,
+}
+
+var commandServeToken = &cli.Command{
+	Name:  "token",
+	Usage: T("serve-token-usage"),
+	Subcommands: []*cli.Command{
+		{
+			Name:      "create",
+			Usage:     T("serve-token-create-usage"),
+			ArgsUsage: "<name>",
+			Flags:     []cli.Flag{&serveFlagFile, &serveFlagScope},
+			Action:    runServeTokenCreate,
+		},
+		{
+			Name:      "revoke",
+			Usage:     T("serve-token-revoke-usage"),
+			ArgsUsage: "<id>",
+			Flags:     []cli.Flag{&serveFlagFile},
+			Action:    runServeTokenRevoke,
+		},
+		{
+			Name:   "list",
+			Usage:  T("serve-token-list-usage"),
+			Flags:  []cli.Flag{&serveFlagFile},
+			Action: runServeTokenList,
+		},
+	},
+}
+
+func runServeTokenCreate(c *cli.Context) error {
+	name := strings.TrimSpace(c.Args().First())
+	if name == "" || c.NArg() > 1 {
+		return cli.ShowSubcommandHelp(c)
+	}
+	scope := c.String("scope")
+	if scope != ScopeRead && scope != ScopeWrite {
+		return fmt.Errorf(Tf("serve-token-bad-scope", scope))
+	}
+
+	path := serveConfigPath(c)
+	config, err := serveLoadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	token, entry := APITokenNew(name, scope)
+	config.Tokens = append(config.Tokens, entry)
+	if err := config.SaveTo(path); err != nil {
+		return err
+	}
+
+	// only the hash is saved, this is the one chance to copy it
+	fmt.Println(Tf("serve-token-created", entry.ID, scope, path))
+	fmt.Println(token)
+	return nil
+}
+
+func runServeTokenRevoke(c *cli.Context) error {
+	id := c.Args().First()
+	if id == "" || c.NArg() > 1 {
+		return cli.ShowSubcommandHelp(c)
+	}
+
+	path := serveConfigPath(c)
+	config, err := serveLoadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	var kept []ServeToken
+	for _, token := range config.Tokens {
+		if token.ID != id {
+			kept = append(kept, token)
+		}
+	}
+	if len(kept) == len(config.Tokens) {
+		return fmt.Errorf(Tf("serve-token-unknown", id))
+	}
+
+	config.Tokens = kept
+	if err := config.SaveTo(path); err != nil {
+		return err
+	}
+
+	fmt.Println(Tf("serve-token-revoked", id, path))
+	return nil
+}
+
+func runServeTokenList(c *cli.Context) error {
+	config, err := serveLoadConfig(serveConfigPath(c))
+	if err != nil {
+		return err
+	}
+
+	for _, token := range config.Tokens {
+		fmt.Printf("%-10s %-6s %s  %s\n", token.ID, token.Scope, token.Created.Format("2006-01-02"), token.Name)
+	}
+	return nil
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+var serveFlagUser = cli.StringFlag{
+	Name:  "user",
+	Usage: T("serve-flag-user"),
+}
+
+var serveFlagRole = cli.StringFlag{
+	Name:  "role",
+	Usage: T("serve-flag-role"),
+	Value: RoleViewer,
+}
+
+var commandServePasswd = &cli.Command{
+	Name:   "passwd",
+	Usage:  T("serve-passwd-usage"),
+	Flags:  []cli.Flag{&serveFlagFile, &serveFlagUser},
+	Action: runServePasswd,
+}
+
+var commandServeUser = &cli.Command{
+	Name:  "user",
+	Usage: T("serve-user-usage"),
+	Subcommands: []*cli.Command{
+		{
+			Name:      "add",
+			Usage:     T("serve-user-add-usage"),
+			ArgsUsage: "<name>",
+			Flags:     []cli.Flag{&serveFlagFile, &serveFlagRole},
+			Action:    runServeUserAdd,
+		},
+		{
+			Name:      "remove",
+			Usage:     T("serve-user-remove-usage"),
+			ArgsUsage: "<name>",
+			Flags:     []cli.Flag{&serveFlagFile},
+			Action:    runServeUserRemove,
+		},
+		{
+			Name:   "list",
+			Usage:  T("serve-user-list-usage"),
+			Flags:  []cli.Flag{&serveFlagFile},
+			Action: runServeUserList,
+		},
+	},
+}
+
+var commandServeTOTP = &cli.Command{
+	Name:  "totp",
+	Usage: T("serve-totp-usage"),
+	Subcommands: []*cli.Command{
+		{
+			Name:   "enable",
+			Usage:  T("serve-totp-enable-usage"),
+			Flags:  []cli.Flag{&serveFlagFile, &serveFlagUser},
+			Action: runServeTOTPEnable,
+		},
+		{
+			Name:   "disable",
+			Usage:  T("serve-totp-disable-usage"),
+			Flags:  []cli.Flag{&serveFlagFile, &serveFlagUser},
+			Action: runServeTOTPDisable,
+		},
+		{
+			Name:   "recovery",
+			Usage:  T("serve-totp-recovery-usage"),
+			Flags:  []cli.Flag{&serveFlagFile, &serveFlagUser},
+			Action: runServeTOTPRecovery,
+		},
+	},
+}
+
+// serveSelectUser takes --user, without it the only user of the config
+func serveSelectUser(c *cli.Context, config *ServeConfig) (*ServeUser, error) {
+	name := c.String("user")
+	if name == "" {
+		if len(config.Users) != 1 {
+			return nil, fmt.Errorf(T("serve-user-missing"))
+		}
+		return &config.Users[0], nil
+	}
+
+	user := config.FindUser(name)
+	if user == nil {
+		return nil, fmt.Errorf(Tf("serve-user-unknown", name))
+	}
+	return user, nil
+}
+
+func runServePasswd(c *cli.Context) error {
+	path := serveConfigPath(c)
+	config, err := serveLoadConfig(path)
+	if err != nil {
+		return err
+	}
+	user, err := serveSelectUser(c, config)
+	if err != nil {
+		return err
+	}
+
+	// the TOTP secret is encrypted with the password, it needs the old one to move over
+	var secret string
+	if user.TOTP != nil {
+		current, err := serveCheckPassword(user)
+		if err != nil {
+			return err
+		}
+		if secret, err = TOTPDecrypt(user.TOTP.Secret, current); err != nil {
+			return err
+		}
+	}
+
+	password, err := serveNewPassword(user.Name)
+	if err != nil {
+		return err
+	}
+	if user.Password, err = generateBcrypt(password); err != nil {
+		return err
+	}
+	if user.TOTP != nil {
+		if user.TOTP.Secret, err = TOTPEncrypt(secret, password); err != nil {
+			return err
+		}
+	}
+	if config.SessionKey == "" {
+		config.SessionKey = SessionNewKey()
+	}
+
+	if err := config.SaveTo(path); err != nil {
+		return err
+	}
+
+	fmt.Println(Tf("serve-passwd-saved", path))
+	return nil
+}
+
+func runServeUserAdd(c *cli.Context) error {
+	name := strings.TrimSpace(c.Args().First())
+	if name == "" || c.NArg() > 1 {
+		return cli.ShowSubcommandHelp(c)
+	}
+	role := c.String("role")
+	if RoleRank(role) < 0 {
+		return fmt.Errorf(Tf("serve-user-bad-role", role, strings.Join(serveRoles, ", ")))
+	}
+
+	path := serveConfigPath(c)
+	config, err := serveLoadConfig(path)
+	if err != nil {
+		return err
+	}
+	if config.FindUser(name) != nil {
+		return fmt.Errorf(Tf("serve-user-exists", name))
+	}
+
+	password, err := serveNewPassword(name)
+	if err != nil {
+		return err
+	}
+	hash, err := generateBcrypt(password)
+	if err != nil {
+		return err
+	}
+
+	config.Users = append(config.Users, ServeUser{Name: name, Password: hash, Role: role})
+	if config.SessionKey == "" {
+		config.SessionKey = SessionNewKey()
+	}
+	if err := config.SaveTo(path); err != nil {
+		return err
+	}
+
+	fmt.Println(Tf("serve-user-added", name, role, path))
+	return nil
+}
+
+func runServeUserRemove(c *cli.Context) error {
+	name := c.Args().First()
+	if name == "" || c.NArg() > 1 {
+		return cli.ShowSubcommandHelp(c)
+	}
+
+	path := serveConfigPath(c)
+	config, err := serveLoadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	var kept []ServeUser
+	admins := 0
+	for _, user := range config.Users {
+		if strings.EqualFold(user.Name, name) {
+			continue
+		}
+		kept = append(kept, user)
+		if user.Role == RoleAdmin {
+			admins++
+		}
+	}
+	if len(kept) == len(config.Users) {
+		return fmt.Errorf(Tf("serve-user-unknown", name))
+	}
+	// nobody could add users in the web UI or fix the config afterwards
+	if admins == 0 {
+		return fmt.Errorf(T("serve-user-last-admin"))
+	}
+
+	config.Users = kept
+	if err := config.SaveTo(path); err != nil {
+		return err
+	}
+
+	fmt.Println(Tf("serve-user-removed", name, path))
+	return nil
+}
+
+func runServeUserList(c *cli.Context) error {
+	config, err := serveLoadConfig(serveConfigPath(c))
+	if err != nil {
+		return err
+	}
+
+	for _, user := range config.Users {
+		totp := ""
+		if user.TOTP != nil {
+			totp = "TOTP"
+		}
+		fmt.Printf("%-40s %-10s %s\n", user.Name, user.Role, totp)
+	}
+	return nil
+}
+
+// serveNewPassword asks twice for a new password
+func serveNewPassword(name string) (string, error) {
+	password, err := serveReadPassword(Tf("serve-passwd-prompt", name))
+	if err != nil {
+		return "", err
+	}
+	if len(password) < ServePasswordMinLength {
+		return "", fmt.Errorf(Tf("serve-passwd-too-short", ServePasswordMinLength))
+	}
+	repeated, err := serveReadPassword(T("serve-passwd-repeat"))
+	if err != nil {
+		return "", err
+	}
+	if password != repeated {
+		return "", fmt.Errorf(T("serve-passwd-mismatch"))
+	}
+
+	return password, nil
+}
+
+// serveCheckPassword asks for the current password of the web user
+func serveCheckPassword(user *ServeUser) (string, error) {
+	password, err := serveReadPassword(Tf("serve-passwd-current", user.Name))
+	if err != nil {
+		return "", err
+	}
+	if !checkPasswordHash(password, user.Password) {
+		return "", fmt.Errorf(T("serve-passwd-wrong"))
+	}
+
+	return password, nil
+}
+
+func runServeTOTPEnable(c *cli.Context) error {
+	path := serveConfigPath(c)
+	config, err := serveLoadConfig(path)
+	if err != nil {
+		return err
+	}
+	user, err := serveSelectUser(c, config)
+	if err != nil {
+		return err
+	}
+	if user.TOTP != nil {
+		return fmt.Errorf(T("serve-totp-already"))
+	}
+
+	password, err := serveCheckPassword(user)
+	if err != nil {
+		return err
+	}
+
+	secret := TOTPNewSecret()
+	uri := TOTPURI(user.Name, secret)
+	fmt.Println(Tf("serve-totp-secret", secret))
+	fmt.Println(uri)
+	// qrencode draws the code in the terminal, nothing leaves the machine
+	if _, err := exec.LookPath("qrencode"); err == nil {
+		qr := exec.Command("qrencode", "-t", "ansiutf8", uri)
+		qr.Stdout = os.Stdout
+		qr.Run()
+	}
+
+	// only save what the app has shown to work
+	code, err := serveReadLine(T("serve-totp-confirm"))
+	if err != nil {
+		return err
+	}
+	if !TOTPVerify(user.Name, secret, code) {
+		return fmt.Errorf(T("serve-totp-wrong-code"))
+	}
+
+	encrypted, err := TOTPEncrypt(secret, password)
+	if err != nil {
+		return err
+	}
+	user.TOTP = &ServeTOTP{Secret: encrypted}
+	if err := serveNewRecovery(user); err != nil {
+		return err
+	}
+
+	if err := config.SaveTo(path); err != nil {
+		return err
+	}
+	fmt.Println(Tf("serve-totp-enabled", path))
+	return nil
+}
+
+func runServeTOTPDisable(c *cli.Context) error {
+	path := serveConfigPath(c)
+	config, err := serveLoadConfig(path)
+	if err != nil {
+		return err
+	}
+	user, err := serveSelectUser(c, config)
+	if err != nil {
+		return err
+	}
+	if user.TOTP == nil {
+		return fmt.Errorf(T("serve-totp-not-enabled"))
+	}
+
+	if _, err := serveCheckPassword(user); err != nil {
+		return err
+	}
+	user.TOTP = nil
+
+	if err := config.SaveTo(path); err != nil {
+		return err
+	}
+	fmt.Println(Tf("serve-totp-disabled", path))
+	return nil
+}
+
+// runServeTOTPRecovery replaces all recovery codes, the old ones become invalid
+func runServeTOTPRecovery(c *cli.Context) error {
+	path := serveConfigPath(c)
+	config, err := serveLoadConfig(path)
+	if err != nil {
+		return err
+	}
+	user, err := serveSelectUser(c, config)
+	if err != nil {
+		return err
+	}
+	if user.TOTP == nil {
+		return fmt.Errorf(T("serve-totp-not-enabled"))
+	}
+
+	if _, err := serveCheckPassword(user); err != nil {
+		return err
+	}
+	if err := serveNewRecovery(user); err != nil {
+		return err
+	}
+
+	return config.SaveTo(path)
+}
+
+func serveNewRecovery(user *ServeUser) error {
+	codes, hashes, err := TOTPNewRecovery()
+	if err != nil {
+		return err
+	}
+	user.TOTP.Recovery = hashes
+
+	fmt.Println(T("serve-totp-recovery-codes"))
+	for _, code := range codes {
+		fmt.Println("  " + code)
+	}
+	return nil
+}
@@ -24,6 +24,11 @@ var setupFlagRAID = cli.StringFlag{
 	Usage: T("setup-flag-raid-device"),
 }
 
+var setupFlagMount = cli.StringSliceFlag{
+	Name:  "mount",
+	Usage: T("setup-flag-mount"),
+}
+
 var commandSetup = &cli.Command{
 	Name:        "setup",
 	Usage:       T("setup-cmd-usage"),
@@ -31,6 +36,7 @@ var commandSetup = &cli.Command{
 	Flags: []cli.Flag{
 		&setupFlagHetzner,
 		&setupFlagRAID,
+		&setupFlagMount,
 	},
 	ArgsUsage: "<hostname>",
 	Action:    runSetup,
@@ -63,7 +69,6 @@ func runSetup(c *cli.Context) error {
 	}
 
 	// check for filesystems to be mounted
-	// N.B. mounts given here are mutually exclusive
 	var mounts []Mount
 	if volume := c.String("hetzner-volume"); volume != "" {
 		mount := Mount{
@@ -72,7 +77,8 @@ func runSetup(c *cli.Context) error {
 			Mountpoint: SystemVarMount,
 		}
 		mounts = append(mounts, mount)
-	} else if device := c.String("raid-device"); device != "" {
+	}
+	if device := c.String("raid-device"); device != "" {
 		mount := Mount{
 			Provider:   "RAID",
 			Identifier: device,
@@ -80,6 +86,21 @@ func runSetup(c *cli.Context) error {
 		}
 		mounts = append(mounts, mount)
 	}
+	for _, spec := range c.StringSlice("mount") {
+		mount, err := ParseMountSpec(spec)
+		if err != nil {
+			return err
+		}
+		mounts = append(mounts, mount)
+	}
+	seen := make(map[string]bool)
+	for _, mount := range mounts {
+		if seen[mount.Mountpoint] {
+			msg := Tf("setup-err-mount-twice", mount.Mountpoint)
+			return fmt.Errorf(msg)
+		}
+		seen[mount.Mountpoint] = true
+	}
 
 	// get the sysadmin email from .gitconfig if possible
 	sysAdmin := fmt.Sprintf("admin@%s", domainName)
@@ -108,16 +129,25 @@ func runSetup(c *cli.Context) error {
 
 	serveConfig := ServeConfig{
 		SysAdmin:   sysAdmin,
-		Password:   "TODO",
+		Users:      []ServeUser{},
 		Address:    "127.0.0.1:3000",
+		SessionKey: SessionNewKey(),
 		ProgName:   "gd-tools",
 		ProgLink:   "https://github.com/railduino/gd-tools",
 		ImprintURL: fmt.Sprintf("https://www.%s/impressum/", domainName),
 		ProtectURL: fmt.Sprintf("https://www.%s/datenschutzerklaerung/", domainName),
+
+		LogsSearchLines: LogsSearchDefault,
+		Health: &HealthConfig{
+			MinFreeMB:      HealthDefaultMinFreeMB,
+			MinFreePercent: HealthDefaultMinFreePercent,
+			CertDays:       HealthDefaultCertDays,
+		},
 	}
 	if err := serveConfig.Save(); err != nil {
 		return err
 	}
+	fmt.Println(Tf("setup-hint-user", sysAdmin))
 
 	systemConfig := SystemConfig{
 		Version:    version,
@@ -128,6 +158,15 @@ func runSetup(c *cli.Context) error {
 		SysAdmin:   sysAdmin,
 		Packages:   packages,
 		Mounts:     mounts,
+		Firewall: FirewallConfig{
+			Rules: DefaultFirewallRules(),
+		},
+		NTP:     DefaultTimeConfig(),
+		Locale:  DefaultLocaleConfig(),
+		Kernel:  DefaultKernelConfig(),
+		Docker:  DefaultDockerConfig(),
+		SSH:     DefaultSSHConfig(),
+		Updates: DefaultUpdatesConfig(sysAdmin),
 	}
 	if err := systemConfig.Save(); err != nil {
 		return err
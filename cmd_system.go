@@ -10,6 +10,7 @@ import (
 	"os/user"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 
 	"github.com/joho/godotenv"
@@ -21,6 +22,18 @@ func init() {
 	AddSubCommand(commandSystem, "any")
 }
 
+const (
+	SystemDockerURL         = "https://download.docker.com/linux"
+	SystemDockerKey         = "/etc/apt/keyrings/docker.gpg"
+	SystemDockerList        = "/etc/apt/sources.list.d/docker.list"
+	SystemDockerFingerprint = "9DC858229FC7DD38854AE2D88D81803C0EBFCD88"
+	SystemSwapFile          = "/swap.img"
+	SystemEnvFile           = "/etc/gd-tools-env"
+)
+
+// distributions with a repository below SystemDockerURL
+var SystemDockerDistros = []string{"ubuntu", "debian", "raspbian"}
+
 var systemFlagProgress = cli.BoolFlag{
 	Name:    "progress",
 	Aliases: []string{"p"},
@@ -33,6 +46,26 @@ var systemFlagUpgrade = cli.BoolFlag{
 	Usage:   T("system-flag-upgrade"),
 }
 
+var systemFlagCheck = cli.BoolFlag{
+	Name:  "check",
+	Usage: T("system-flag-check"),
+}
+
+var systemFlagOnly = cli.StringSliceFlag{
+	Name:  "only",
+	Usage: T("system-flag-only"),
+}
+
+var systemFlagSkip = cli.StringSliceFlag{
+	Name:  "skip",
+	Usage: T("system-flag-skip"),
+}
+
+var systemFlagResume = cli.BoolFlag{
+	Name:  "resume",
+	Usage: T("system-flag-resume"),
+}
+
 var commandSystem = &cli.Command{
 	Name:        "system",
 	Usage:       T("system-cmd-usage"),
@@ -41,10 +74,57 @@ var commandSystem = &cli.Command{
 		&mainFlagDryRun,
 		&systemFlagProgress,
 		&systemFlagUpgrade,
+		&systemFlagCheck,
+		&systemFlagOnly,
+		&systemFlagSkip,
+		&systemFlagResume,
+	},
+	Subcommands: []*cli.Command{
+		&commandSystemRestore,
 	},
 	Action: runSystem,
 }
 
+var commandSystemRestore = cli.Command{
+	Name:      "restore",
+	Usage:     T("system-restore-usage"),
+	ArgsUsage: "[timestamp]",
+	Flags:     []cli.Flag{&mainFlagDryRun},
+	Action:    runSystemRestore,
+}
+
+func runSystemRestore(c *cli.Context) error {
+	if c.NArg() < 1 {
+		sets, err := BackupList()
+		if err != nil {
+			return err
+		}
+		if len(sets) == 0 {
+			fmt.Println(Tf("system-restore-none", BackupRoot))
+			return nil
+		}
+		for _, set := range sets {
+			fmt.Printf("%s  %s\n", set.Stamp, Tf("system-restore-files", len(set.Entries)))
+			for _, entry := range set.Entries {
+				fmt.Printf("    %s\n", entry.Path)
+			}
+		}
+		return nil
+	}
+
+	if euid := os.Geteuid(); euid != 0 {
+		msg := T("system-only-root")
+		return fmt.Errorf(msg)
+	}
+
+	set, err := BackupLoad(c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	return set.Restore(c.Bool("dry"))
+}
+
 func runSystem(c *cli.Context) error {
 	localPath, err := os.Getwd()
 	if err != nil {
@@ -55,7 +135,9 @@ func runSystem(c *cli.Context) error {
 	if CheckEnv("dev") {
 		hostName := filepath.Base(localPath)
 		rootUser := fmt.Sprintf("root@%s", hostName)
-		DeployFetchLetsEncrypt(c, rootUser)
+		if err := DeployFetchLetsEncrypt(c, rootUser); err != nil {
+			return err
+		}
 
 		return ShellEditor(SystemConfigName)
 	}
@@ -73,42 +155,30 @@ func runSystem(c *cli.Context) error {
 	}
 
 	var systemConfig SystemConfig
-	if err := json.Unmarshal(content, &systemConfig); err != nil {
-		return err
+	schema, err := ConfigDecode(content, systemMigrations, &systemConfig)
+	if err != nil {
+		return fmt.Errorf("%s: %w", systemConfigFile, err)
+	}
+	if schema < ConfigSchema {
+		fmt.Println(Tf("config-hint-migrate", systemConfigFile, schema, ConfigSchema))
+	}
+	if err := systemConfig.Validate(); err != nil {
+		return fmt.Errorf("%s:\n%w", systemConfigFile, err)
 	}
 	systemConfig.DryRun = dryRun
 	systemConfig.Progress = c.Bool("progress")
 	systemConfig.Upgrade = c.Bool("upgrade")
 
-	if err := systemConfig.SetTimeZone(); err != nil {
-		return err
-	}
-	if err := systemConfig.SetHostName(); err != nil {
-		return err
-	}
-	if err := systemConfig.AddSwapSpace(); err != nil {
-		return err
-	}
-	if err := systemConfig.AddDockerRepo(); err != nil {
-		return err
-	}
-	if err := systemConfig.InstallPackages(); err != nil {
-		return err
-	}
-	if err := systemConfig.SetupMounts(); err != nil {
-		return err
-	}
-	if err := systemConfig.ActivateFirewall(); err != nil {
-		return err
-	}
-	if err := systemConfig.AddToolsUser(); err != nil {
+	steps, err := SystemSelectSteps(c.StringSlice("only"), c.StringSlice("skip"))
+	if err != nil {
 		return err
 	}
-	if err := systemConfig.CollectData(); err != nil {
-		return err
+
+	if c.Bool("check") {
+		return systemConfig.RunSystemCheck(steps)
 	}
 
-	return nil
+	return systemConfig.RunSystemSteps(steps, c.Bool("resume"), content)
 }
 
 func (sc *SystemConfig) SetTimeZone() error {
@@ -147,53 +217,136 @@ func (sc *SystemConfig) SetHostName() error {
 	return ShellCmd(sc.DryRun, set_hostname)
 }
 
-func (sc *SystemConfig) AddSwapSpace() error {
-	if sc.SwapSpace <= 0 {
-		fmt.Println(T("system-swapfile-zero"))
-		return nil
-	}
-	swapSize := fmt.Sprintf("%dG", sc.SwapSpace)
+func (sc *SystemConfig) AddDockerRepo() error {
+	gpgKey := SystemDockerKey
+	dockerDeb := SystemDockerList
 
-	swapFile := "/swap.img"
-	if _, err := os.Stat(swapFile); err == nil {
-		msg := Tf("system-swapfile-exist", swapFile)
-		fmt.Println(msg)
-		return nil
+	repo, err := systemDockerRepo()
+	if err != nil {
+		return err
 	}
+	channel := sc.Docker.GetChannel()
+	if channel != "stable" && channel != "test" {
+		return fmt.Errorf(Tf("docker-err-channel", channel))
+	}
+	aptSource := systemDockerSource(repo, gpgKey, channel)
 
-	cmds := []string{
-		fmt.Sprintf("fallocate -l %s %s", swapSize, swapFile),
-		fmt.Sprintf("chmod 600 %s", swapFile),
-		fmt.Sprintf("mkswap %s", swapFile),
-		fmt.Sprintf("swapon %s", swapFile),
+	// the keyring only needs a download if it is missing or wrong
+	if systemDockerKeyValid(gpgKey) {
+		fmt.Println(Tf("system-docker-key-okay", gpgKey))
+	} else if sc.DryRun {
+		cmd := fmt.Sprintf("fetch %s/gpg into %s", repo.URL, gpgKey)
+		if err := ShellCmd(true, cmd); err != nil {
+			return err
+		}
+	} else {
+		if err := systemDockerFetchKey(repo.URL, gpgKey); err != nil {
+			return err
+		}
 	}
 
-	if err := ShellCmds(sc.DryRun, cmds); err != nil {
+	changed, err := FileWriteIfChanged(sc.DryRun, dockerDeb, []byte(aptSource), 0644)
+	if err != nil {
 		return err
 	}
+	if !changed {
+		fmt.Println(Tf("system-docker-list-okay", dockerDeb))
+	}
 
-	if sc.DryRun {
-		msg := Tf("system-swapfile-fstab", swapFile)
-		fmt.Println(msg)
-		return nil
+	return nil
+}
+
+// SystemDockerRepo describes the Docker repository matching this host
+type SystemDockerRepo struct {
+	URL      string // e.g. https://download.docker.com/linux/debian
+	CodeName string // e.g. bookworm
+	Arch     string // dpkg architecture, e.g. armhf
+}
+
+func systemDockerRepo() (SystemDockerRepo, error) {
+	envMap, err := godotenv.Read("/etc/os-release")
+	if err != nil {
+		return SystemDockerRepo{}, err
+	}
+
+	// derivatives like Linux Mint name their upstream in ID_LIKE
+	distro := ""
+	candidates := append([]string{envMap["ID"]}, strings.Fields(envMap["ID_LIKE"])...)
+	for _, candidate := range candidates {
+		if slices.Contains(SystemDockerDistros, candidate) {
+			distro = candidate
+			break
+		}
+	}
+	if distro == "" {
+		return SystemDockerRepo{}, fmt.Errorf(Tf("system-err-docker-distro", envMap["ID"]))
+	}
+
+	codeName := envMap["VERSION_CODENAME"]
+	if distro == "ubuntu" && envMap["UBUNTU_CODENAME"] != "" {
+		codeName = envMap["UBUNTU_CODENAME"]
+	}
+	if codeName == "" {
+		return SystemDockerRepo{}, fmt.Errorf(Tf("system-err-docker-distro", envMap["ID"]))
+	}
+
+	arch, err := systemDockerArch()
+	if err != nil {
+		return SystemDockerRepo{}, err
+	}
+
+	repo := SystemDockerRepo{
+		URL:      SystemDockerURL + "/" + distro,
+		CodeName: codeName,
+		Arch:     arch,
 	}
 
-	return FileAddLine("/etc/fstab",
-		`^/swap\.img\s+none\s+swap\s+sw\s+0\s+0$`,
-		"/swap.img none swap sw 0 0")
+	return repo, nil
 }
 
-func (sc *SystemConfig) AddDockerRepo() error {
-	dockerURL := "https://download.docker.com/linux/ubuntu"
-	gpgKey := "/etc/apt/keyrings/docker.gpg"
-	dockerDeb := "/etc/apt/sources.list.d/docker.list"
+// systemDockerArch prefers dpkg, because a 32 bit userland may run on a 64 bit kernel
+func systemDockerArch() (string, error) {
+	arch, err := ShellOutput("dpkg --print-architecture")
+	if err != nil {
+		switch runtime.GOARCH {
+		case "amd64", "arm64":
+			arch = runtime.GOARCH
+		case "arm":
+			arch = "armhf"
+		default:
+			arch = runtime.GOARCH
+		}
+	}
 
-	if sc.DryRun {
-		cmd := fmt.Sprintf("install Docker from %s ...", dockerURL)
-		return ShellCmd(true, cmd)
+	switch arch {
+	case "amd64", "arm64", "armhf":
+		return arch, nil
 	}
 
-	if err := os.MkdirAll("/etc/apt/keyrings", 0755); err != nil {
+	return "", fmt.Errorf("unsupported architecture: %s", arch)
+}
+
+func systemDockerSource(repo SystemDockerRepo, gpgKey, channel string) string {
+	return fmt.Sprintf("deb [arch=%s signed-by=%s] %s %s %s\n",
+		repo.Arch, gpgKey, repo.URL, repo.CodeName, channel)
+}
+
+// systemDockerKeyValid checks the keyring for the fingerprint of the Docker release key
+func systemDockerKeyValid(gpgKey string) bool {
+	if _, err := os.Stat(gpgKey); err != nil {
+		return false
+	}
+
+	output, err := ShellOutput("gpg --show-keys --with-colons " + gpgKey)
+	if err != nil {
+		return false
+	}
+
+	return strings.Contains(output, ":"+SystemDockerFingerprint+":")
+}
+
+func systemDockerFetchKey(dockerURL, gpgKey string) error {
+	if err := os.MkdirAll(filepath.Dir(gpgKey), 0755); err != nil {
 		return err
 	}
 
@@ -213,31 +366,13 @@ func (sc *SystemConfig) AddDockerRepo() error {
 	if err := cmd.Run(); err != nil {
 		return err
 	}
-	if err := os.WriteFile(gpgKey, out.Bytes(), 0644); err != nil {
-		return err
-	}
-
-	envMap, err := godotenv.Read("/etc/os-release")
-	if err != nil {
+	if err := FileWriteAtomic(gpgKey, out.Bytes(), 0644); err != nil {
 		return err
 	}
-	codeName := envMap["VERSION_CODENAME"]
-
-	var arch string
-	switch runtime.GOARCH {
-	case "amd64":
-		arch = "amd64"
-	case "arm64":
-		arch = "arm64"
-	default:
-		return fmt.Errorf("unsupported GOARCH: %s", runtime.GOARCH)
-	}
-
-	aptSource := fmt.Sprintf("deb [arch=%s signed-by=%s] %s %s stable\n",
-		arch, gpgKey, dockerURL, codeName)
 
-	if err := os.WriteFile(dockerDeb, []byte(aptSource), 0644); err != nil {
-		return err
+	if !systemDockerKeyValid(gpgKey) {
+		os.Remove(gpgKey)
+		return fmt.Errorf(Tf("system-err-docker-key", dockerURL+"/gpg"))
 	}
 
 	return nil
@@ -280,117 +415,43 @@ func (sc *SystemConfig) SetupMounts() error {
 	}
 
 	for _, mount := range sc.Mounts {
-		var err error
-		switch provider := strings.ToLower(mount.Provider); provider {
-		case "hetzner":
-			err = systemMountHetzner(sc.DryRun, mount.Identifier, mount.Mountpoint)
-		case "raid":
-			err = systemMountRAID(sc.DryRun, mount.Identifier, mount.Mountpoint)
-		default:
-			err = fmt.Errorf("Provider '%s' ist noch nicht implementiert.", provider)
-		}
+		provider, err := GetMountProvider(mount.Provider)
 		if err != nil {
 			return err
 		}
-	}
-
-	return nil
-}
-
-func systemMountHetzner(dryRun bool, id, target string) error {
-	if _, err := os.Stat(target + "/lost+found"); err == nil {
-		fmt.Printf("Volume '%s' bereits eingebunden unter %s\n", id, target)
-		return nil
-	}
-
-	legacy := "/mnt/HC_Volume_" + id
-	if _, err := os.Stat(legacy + "/lost+found"); err == nil {
-		if err := ShellCmd(dryRun, "umount "+legacy); err != nil {
+		if err := provider.Validate(mount); err != nil {
 			return err
 		}
-	} else if os.IsNotExist(err) {
-		return fmt.Errorf("Volume %s nicht gefunden: %v", legacy, err)
-	}
-
-	cmds := []string{
-		"mkdir -p " + target,
-		fmt.Sprintf("sed -i -e s#%s#%s# /etc/fstab", legacy, target),
-		"systemctl daemon-reload",
-		"mount -a",
-		"rmdir " + legacy,
-		"chmod 0755 " + target,
-	}
-
-	return ShellCmds(dryRun, cmds)
-}
-
-func systemMountRAID(dryRun bool, id, target string) error {
-	if _, err := os.Stat(target + "/lost+found"); err == nil {
-		fmt.Println("RAID-Volume bereits eingebunden unter:", target)
-		return nil
-	}
-
-	mkdir := fmt.Sprintf("mkdir -p %s", target)
-	if err := ShellCmd(dryRun, mkdir); err != nil {
-		return err
-	}
-
-	uuid, err := ShellGetDeviceUUID(dryRun, id)
-	if err != nil {
-		return err
-	}
-	line := fmt.Sprintf("UUID=%s %s ext4 defaults,nofail 0 0", uuid, target)
-	if dryRun {
-		fmt.Printf("[dry] /etc/fstab: '%s'\n", line)
-	} else {
-		if err := FileAddLine("/etc/fstab", uuid, line); err != nil {
+		if err := PackagesEnsure(sc.DryRun, provider.Packages()); err != nil {
+			return err
+		}
+		if err := provider.Setup(sc.DryRun, mount); err != nil {
 			return err
 		}
 	}
 
-	cmds := []string{
-		"systemctl daemon-reload",
-		"mount -a",
-	}
-
-	return ShellCmds(dryRun, cmds)
+	return nil
 }
 
-func (sc *SystemConfig) ActivateFirewall() error {
-	if err := ShellCmd(sc.DryRun, "ufw allow OpenSSH"); err != nil {
-		return err
-	}
-	if err := ShellCmd(sc.DryRun, "ufw allow Nginx_#_Full"); err != nil {
-		return err
-	}
-
-	matched, err := ShellMatch("ufw status", "Status: active")
-	if err != nil {
-		return err
-	}
-	if !matched {
-		if err := ShellCmd(sc.DryRun, "ufw enable"); err != nil {
-			return err
-		}
+// ToolsUserEnsure creates the gd-tools account if it is missing
+func ToolsUserEnsure(dryRun bool) error {
+	if _, err := user.Lookup("gd-tools"); err == nil {
+		return nil
 	}
 
-	return nil
+	return ShellCmd(dryRun, "useradd -r -m -s /bin/bash gd-tools")
 }
 
 func (sc *SystemConfig) AddToolsUser() error {
-	envFile := "/etc/gd-tools-env"
-	if err := os.WriteFile(envFile, []byte("prod\n"), 0o444); err != nil {
+	envFile := SystemEnvFile
+	if _, err := FileWriteIfChanged(sc.DryRun, envFile, []byte("prod\n"), 0o444); err != nil {
 		return err
 	}
 
-	gdUser, err := user.Lookup("gd-tools")
-	if err != nil {
-		userAdd := fmt.Sprintf("useradd -r -m -s /bin/bash gd-tools")
-		if err := ShellCmd(sc.DryRun, userAdd); err != nil {
-			return err
-		}
+	if err := ToolsUserEnsure(sc.DryRun); err != nil {
+		return err
 	}
-	gdUser, err = user.Lookup("gd-tools")
+	gdUser, err := user.Lookup("gd-tools")
 	if err != nil {
 		return err
 	}
@@ -412,17 +473,40 @@ func (sc *SystemConfig) AddToolsUser() error {
 
 	sshCmds := []string{
 		"install -o gd-tools -g gd-tools -m 700 -d /home/gd-tools/.ssh",
-		"install -o gd-tools -g gd-tools -m 600 /root/.ssh/authorized_keys /home/gd-tools/.ssh",
 		"install -o gd-tools -g gd-tools -m 755 -d " + SystemDataRoot,
 		"install -o gd-tools -g gd-tools -m 755 -d " + SystemLogsRoot,
+		"install -o gd-tools -g gd-tools -m 700 -d " + ServeStateDir,
 	}
 	if err := ShellCmds(sc.DryRun, sshCmds); err != nil {
 		return err
 	}
 
+	// without declared admins every root key also gets gd-tools access
+	if len(sc.Admins) == 0 {
+		return toolsCopyRootKeys(sc.DryRun, gdUser)
+	}
+
 	return nil
 }
 
+// toolsCopyRootKeys copies root's authorized_keys through the backup set, so restore can undo it
+func toolsCopyRootKeys(dryRun bool, gdUser *user.User) error {
+	content, err := os.ReadFile("/root/.ssh/authorized_keys")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(gdUser.HomeDir, ".ssh", "authorized_keys")
+	changed, err := FileWriteIfChanged(dryRun, path, content, 0600)
+	if err != nil || !changed || dryRun {
+		return err
+	}
+
+	uid, _ := strconv.Atoi(gdUser.Uid)
+	gid, _ := strconv.Atoi(gdUser.Gid)
+	return os.Chown(path, uid, gid)
+}
+
 func (sc *SystemConfig) CollectData() error {
 	if _, err := os.ReadDir("/etc/letsencrypt"); err != nil {
 		certbotOpts := fmt.Sprintf("--nginx --non-interactive --agree-tos --email %s", sc.SysAdmin)
@@ -434,6 +518,9 @@ func (sc *SystemConfig) CollectData() error {
 	if _, err := os.ReadDir("/etc/letsencrypt"); err != nil {
 		return err
 	}
+	if err := CertInstallHook(sc.DryRun); err != nil {
+		return err
+	}
 
 	gdtUser, err := user.Lookup("gd-tools")
 	if err != nil {
@@ -457,7 +544,7 @@ func (sc *SystemConfig) CollectData() error {
 			return err
 		}
 		uidPath := filepath.Join("/etc/letsencrypt", SystemIDsName)
-		if err := os.WriteFile(uidPath, content, 0644); err != nil {
+		if _, err := FileWriteIfChanged(false, uidPath, content, 0644); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ConfigSchema is the current layout of gd-tools-system.json and gd-tools-serve.json
+const ConfigSchema = 3
+
+// ConfigMigration upgrades the raw JSON of a config file from one schema to the next
+type ConfigMigration func(raw map[string]any) error
+
+// migrations keyed by the schema they start from (files without "schema" are 1)
+var (
+	systemMigrations = map[int]ConfigMigration{
+		1: migrateSystemV1,
+		2: migrateNothing,
+	}
+	serveMigrations = map[int]ConfigMigration{
+		1: migrateServeV1,
+		2: migrateServeV2,
+	}
+)
+
+var configHostRE = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+
+// ConfigDecode migrates content in memory and decodes it strictly into v.
+// It returns the schema found in the file.
+func ConfigDecode(content []byte, migrations map[int]ConfigMigration, v any) (int, error) {
+	raw := make(map[string]any)
+	if err := json.Unmarshal(content, &raw); err != nil {
+		return 0, err
+	}
+
+	schema := 1
+	if value, ok := raw["schema"].(float64); ok {
+		schema = int(value)
+	}
+	if schema > ConfigSchema {
+		return schema, fmt.Errorf(Tf("config-err-schema-newer", schema, ConfigSchema))
+	}
+
+	for current := schema; current < ConfigSchema; current++ {
+		migrate, ok := migrations[current]
+		if !ok {
+			return schema, fmt.Errorf(Tf("config-err-no-migration", current))
+		}
+		if err := migrate(raw); err != nil {
+			return schema, err
+		}
+		raw["schema"] = current + 1
+	}
+
+	migrated, err := json.Marshal(raw)
+	if err != nil {
+		return schema, err
+	}
+
+	// a typo in a key must not be ignored
+	decoder := json.NewDecoder(bytes.NewReader(migrated))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return schema, fmt.Errorf(Tf("config-err-decode", err))
+	}
+
+	return schema, nil
+}
+
+// migrateSystemV1 adds the sections that came with schema 2
+func migrateSystemV1(raw map[string]any) error {
+	if _, ok := raw["firewall"]; !ok {
+		raw["firewall"] = FirewallConfig{Rules: DefaultFirewallRules()}
+	}
+	if _, ok := raw["admins"]; !ok {
+		raw["admins"] = []AdminKey{}
+	}
+	if raw["swap_space"] == nil {
+		raw["swap_space"] = 0
+	}
+
+	return nil
+}
+
+// migrateServeV1 fills in the listen address, which used to be a runtime default
+func migrateServeV1(raw map[string]any) error {
+	if address, _ := raw["address"].(string); address == "" {
+		raw["address"] = "127.0.0.1:3000"
+	}
+
+	return nil
+}
+
+// migrateServeV2 turns the single password (and TOTP) of sys_admin into the first admin user
+func migrateServeV2(raw map[string]any) error {
+	if _, ok := raw["users"]; ok {
+		return nil
+	}
+
+	users := []map[string]any{}
+	if password, _ := raw["password"].(string); password != "" && password != "TODO" {
+		user := map[string]any{
+			"name":     raw["sys_admin"],
+			"password": password,
+			"role":     RoleAdmin,
+		}
+		if totp, ok := raw["totp"]; ok {
+			user["totp"] = totp
+		}
+		users = append(users, user)
+	}
+	raw["users"] = users
+	delete(raw, "password")
+	delete(raw, "totp")
+
+	return nil
+}
+
+// migrateNothing is for a schema that changed only the other config file
+func migrateNothing(raw map[string]any) error {
+	return nil
+}
+
+// Validate checks the content of the system config beyond its syntax
+func (sc *SystemConfig) Validate() error {
+	var problems []error
+
+	if sc.TimeZone == "" {
+		problems = append(problems, fmt.Errorf(T("config-err-time-zone-empty")))
+	} else if _, err := time.LoadLocation(sc.TimeZone); err != nil {
+		problems = append(problems, fmt.Errorf(Tf("config-err-time-zone", sc.TimeZone)))
+	}
+
+	if !configHostRE.MatchString(strings.ToLower(sc.HostName)) {
+		problems = append(problems, fmt.Errorf(Tf("config-err-host-name", sc.HostName)))
+	}
+	if sc.DomainName != "" && !strings.HasSuffix(sc.HostName, "."+sc.DomainName) {
+		problems = append(problems, fmt.Errorf(Tf("config-err-domain-name", sc.DomainName, sc.HostName)))
+	}
+
+	if len(sc.Packages) == 0 {
+		problems = append(problems, fmt.Errorf(T("config-err-packages")))
+	}
+	for _, pkgName := range sc.Packages {
+		if strings.TrimSpace(pkgName) == "" {
+			problems = append(problems, fmt.Errorf(T("config-err-packages")))
+		}
+	}
+
+	mountpoints := make(map[string]bool)
+	for _, mount := range sc.Mounts {
+		provider, err := GetMountProvider(mount.Provider)
+		if err != nil {
+			problems = append(problems, err)
+			continue
+		}
+		if err := provider.Validate(mount); err != nil {
+			problems = append(problems, err)
+		}
+		if mountpoints[mount.Mountpoint] {
+			problems = append(problems, fmt.Errorf(Tf("setup-err-mount-twice", mount.Mountpoint)))
+		}
+		mountpoints[mount.Mountpoint] = true
+	}
+
+	if sc.NTP != nil {
+		problems = append(problems, sc.NTP.Validate())
+	}
+	if sc.Locale != nil {
+		problems = append(problems, sc.Locale.Validate())
+	}
+	if sc.Swap != nil {
+		problems = append(problems, sc.Swap.Validate())
+	}
+	if sc.Kernel != nil {
+		problems = append(problems, sc.Kernel.Validate(sc))
+	}
+	if sc.Docker != nil {
+		problems = append(problems, sc.Docker.Validate())
+	}
+	if sc.Updates != nil {
+		problems = append(problems, sc.Updates.Validate())
+	}
+
+	return errors.Join(problems...)
+}
+
+// Validate checks the content of the serve config beyond its syntax
+func (sc *ServeConfig) Validate() error {
+	var problems []error
+
+	if sc.SysAdmin == "" {
+		problems = append(problems, fmt.Errorf(T("config-err-sys-admin")))
+	}
+	admins := 0
+	seen := make(map[string]bool)
+	for _, user := range sc.Users {
+		name := strings.ToLower(user.Name)
+		if name == "" || seen[name] {
+			problems = append(problems, fmt.Errorf(Tf("config-err-user-name", user.Name)))
+		}
+		seen[name] = true
+		if RoleRank(user.Role) < 0 {
+			problems = append(problems, fmt.Errorf(Tf("config-err-user-role", user.Name, user.Role)))
+		}
+		if user.Role == RoleAdmin {
+			admins++
+		}
+		if user.Password == "" {
+			problems = append(problems, fmt.Errorf(Tf("config-err-password", user.Name)))
+		}
+		if user.TOTP != nil && (user.TOTP.Secret == "" || len(user.TOTP.Recovery) == 0) {
+			problems = append(problems, fmt.Errorf(Tf("config-err-totp", user.Name)))
+		}
+	}
+	if admins == 0 {
+		problems = append(problems, fmt.Errorf(T("config-err-no-admin")))
+	}
+	if _, _, err := net.SplitHostPort(sc.Address); err != nil {
+		problems = append(problems, fmt.Errorf(Tf("config-err-address", sc.Address)))
+	}
+
+	if sc.MetricsToken != "" && len(sc.MetricsToken) < 16 {
+		problems = append(problems, fmt.Errorf(T("config-err-metrics-token")))
+	}
+	if sc.LogsSearchLines < 0 {
+		problems = append(problems, fmt.Errorf(T("config-err-logs-search")))
+	}
+	if sc.Health != nil {
+		problems = append(problems, sc.Health.Validate())
+	}
+
+	tokens := make(map[string]bool)
+	for _, token := range sc.Tokens {
+		if token.ID == "" || tokens[token.ID] || len(token.Hash) != 64 {
+			problems = append(problems, fmt.Errorf(Tf("config-err-token", token.ID)))
+		}
+		tokens[token.ID] = true
+		if token.Scope != ScopeRead && token.Scope != ScopeWrite {
+			problems = append(problems, fmt.Errorf(Tf("config-err-token-scope", token.ID, token.Scope)))
+		}
+	}
+
+	for _, link := range []string{sc.ProgLink, sc.ImprintURL, sc.ProtectURL} {
+		if link == "" {
+			continue
+		}
+		if parsed, err := url.Parse(link); err != nil || parsed.Host == "" {
+			problems = append(problems, fmt.Errorf(Tf("config-err-url", link)))
+		}
+	}
+
+	return errors.Join(problems...)
+}
@@ -98,10 +98,15 @@ func DeployParsedTemplate(c *cli.Context, tmplName, destPath, receiver, chmod st
 
 // Typ 3: DeployLocal: überträgt lokale echte Dateien oder Verzeichnisse unverändert
 func DeployLocal(c *cli.Context, localPath, destPath, receiver, chmod string) error {
+	return DeployLocalOwned(c, localPath, destPath, receiver, "root:root", chmod)
+}
+
+// DeployLocalOwned is DeployLocal with another owner, e.g. "root:gd-tools"
+func DeployLocalOwned(c *cli.Context, localPath, destPath, receiver, chown, chmod string) error {
 	rsync := DeployRsync{
 		DryRun: c.Bool("dry"),
 		Flags: []string{
-			"--chown=root:root",
+			"--chown=" + chown,
 			"--chmod=" + chmod,
 		},
 		Local:    localPath,
@@ -114,24 +119,3 @@ func DeployLocal(c *cli.Context, localPath, destPath, receiver, chmod string) er
 
 	return rsync.Execute()
 }
-
-// Utility: holt /etc/letsencrypt vom Zielsystem nach lokal
-func DeployFetchLetsEncrypt(c *cli.Context, rootUser string) {
-	dryRun := c.Bool("dry")
-
-	rsyncPrefix := "rsync -avz"
-	if !c.Bool("debug") {
-		rsyncPrefix += " --quiet"
-	}
-	rsyncCmd := fmt.Sprintf("%s %s:/etc/letsencrypt/ letsencrypt", rsyncPrefix, rootUser)
-
-	if err := ShellCmd(dryRun, rsyncCmd); err != nil {
-		fmt.Println("Ignore error:", err)
-	}
-
-	if !dryRun {
-		if systemConfig, err := ReadSystemConfig(false); err == nil {
-			systemConfig.Save()
-		}
-	}
-}
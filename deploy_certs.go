@@ -0,0 +1,462 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	CertRemoteDir = "/etc/letsencrypt"
+	CertHookPath  = CertRemoteDir + "/renewal-hooks/deploy/gd-tools.sh"
+	CertSyncState = ".gd-tools-sync.json"
+)
+
+// CertLineage beschreibt ein Zertifikat unter live/<name>/cert.pem
+type CertLineage struct {
+	Name     string
+	Serial   string
+	NotAfter time.Time
+}
+
+// CertSync gleicht das lokale letsencrypt/ mit /etc/letsencrypt auf dem Host ab
+type CertSync struct {
+	DryRun   bool
+	Debug    bool
+	Push     bool
+	RootUser string
+	Local    string
+	Stage    string
+}
+
+// DeployFetchLetsEncrypt holt neuere Zertifikate vom Host, ohne etwas zu übertragen
+func DeployFetchLetsEncrypt(c *cli.Context, rootUser string) error {
+	certSync := CertSync{
+		DryRun:   c.Bool("dry"),
+		Debug:    c.Bool("debug"),
+		Push:     false,
+		RootUser: rootUser,
+		Local:    LetsEncryptDir,
+	}
+
+	if err := certSync.Execute(); err != nil {
+		return err
+	}
+
+	return certUpdateSystemIDs(certSync.DryRun)
+}
+
+// DeploySyncLetsEncrypt gleicht die Zertifikate in beide Richtungen ab
+func DeploySyncLetsEncrypt(c *cli.Context, rootUser string) error {
+	certSync := CertSync{
+		DryRun:   c.Bool("dry"),
+		Debug:    c.Bool("debug"),
+		Push:     true,
+		RootUser: rootUser,
+		Local:    LetsEncryptDir,
+	}
+
+	if err := certSync.Execute(); err != nil {
+		return err
+	}
+
+	return certUpdateSystemIDs(certSync.DryRun)
+}
+
+// Execute holt den Stand vom Host, vergleicht pro Lineage und überträgt nur Neueres.
+// Mit --dry wird ebenfalls geholt (nur in ein temporäres Verzeichnis), damit der Plan pro Lineage sichtbar ist.
+func (cs *CertSync) Execute() error {
+	remoteExists, err := certRemoteHasDir(cs.RootUser, CertRemoteDir)
+	if err != nil {
+		return fmt.Errorf(Tf("certs-err-check", cs.RootUser, err))
+	}
+
+	if !remoteExists {
+		fmt.Println(Tf("certs-remote-missing", cs.RootUser, CertRemoteDir))
+		return cs.pushAll()
+	}
+
+	stage, err := os.MkdirTemp("", "letsencrypt-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stage)
+	cs.Stage = stage
+
+	fetchCmd := fmt.Sprintf("rsync -az%s %s:%s/ %s/", cs.quiet(), cs.RootUser, CertRemoteDir, cs.Stage)
+	if err := ShellCmd(false, fetchCmd); err != nil {
+		return fmt.Errorf(Tf("certs-err-fetch", cs.RootUser, err))
+	}
+
+	if !cs.DryRun {
+		if err := os.MkdirAll(cs.Local, 0755); err != nil {
+			return err
+		}
+	}
+
+	// alles außer den Lineages ist auf dem Host maßgeblich (accounts, IDs usw.)
+	mirrorCmd := fmt.Sprintf("rsync -a%s --exclude=/archive/ --exclude=/live/ --exclude=/renewal/ --exclude=/%s %s/ %s/",
+		cs.quiet(), CertSyncState, cs.Stage, cs.Local)
+	if err := ShellCmd(cs.DryRun, mirrorCmd); err != nil {
+		return err
+	}
+
+	// eine defekte Lineage wird auf beiden Seiten übersprungen, die anderen laufen weiter
+	broken := make(map[string]bool)
+	skip := func(name string, err error) {
+		if !broken[name] {
+			fmt.Println(Tf("certs-lineage-broken", name, err))
+		}
+		broken[name] = true
+	}
+	localCerts, err := certReadLineages(cs.Local, skip)
+	if err != nil {
+		return err
+	}
+	remoteCerts, err := certReadLineages(cs.Stage, skip)
+	if err != nil {
+		return err
+	}
+	state := certLoadState(cs.Local)
+
+	var names []string
+	seen := make(map[string]bool)
+	for _, lineages := range []map[string]CertLineage{localCerts, remoteCerts} {
+		for name := range lineages {
+			if !seen[name] && !broken[name] {
+				names = append(names, name)
+			}
+			seen[name] = true
+		}
+	}
+	sort.Strings(names)
+
+	var pushList []string
+	var conflicts []string
+	for _, name := range names {
+		local, hasLocal := localCerts[name]
+		remote, hasRemote := remoteCerts[name]
+		base := state[name]
+
+		switch {
+		case hasLocal && hasRemote && local.Serial == remote.Serial:
+			fmt.Println(Tf("certs-lineage-insync", name, local.Serial, certDate(local.NotAfter)))
+			state[name] = local.Serial
+
+		case !hasLocal:
+			fmt.Println(Tf("certs-lineage-pull", name, certDate(remote.NotAfter)))
+			if err := cs.pullLineage(name); err != nil {
+				return err
+			}
+			state[name] = remote.Serial
+
+		case !hasRemote:
+			if base != "" && base == local.Serial {
+				conflicts = append(conflicts, name)
+				fmt.Println(Tf("certs-lineage-conflict", name, T("certs-conflict-removed")))
+				continue
+			}
+			if !cs.Push {
+				fmt.Println(Tf("certs-lineage-local", name))
+				continue
+			}
+			fmt.Println(Tf("certs-lineage-push", name, certDate(local.NotAfter)))
+			pushList = append(pushList, name)
+			state[name] = local.Serial
+
+		default:
+			action := certDecide(local, remote, base)
+			if action == "pull" {
+				fmt.Println(Tf("certs-lineage-pull", name, certDate(remote.NotAfter)))
+				if err := cs.pullLineage(name); err != nil {
+					return err
+				}
+				state[name] = remote.Serial
+				continue
+			}
+			if action == "push" {
+				if !cs.Push {
+					fmt.Println(Tf("certs-lineage-local", name))
+					continue
+				}
+				fmt.Println(Tf("certs-lineage-push", name, certDate(local.NotAfter)))
+				pushList = append(pushList, name)
+				state[name] = local.Serial
+				continue
+			}
+			conflicts = append(conflicts, name)
+			reason := Tf("certs-conflict-both",
+				local.Serial, certDate(local.NotAfter),
+				remote.Serial, certDate(remote.NotAfter))
+			fmt.Println(Tf("certs-lineage-conflict", name, reason))
+		}
+	}
+
+	if len(pushList) > 0 {
+		if err := cs.pushLineages(pushList); err != nil {
+			return err
+		}
+	}
+
+	if !cs.DryRun {
+		if err := certSaveState(cs.Local, state); err != nil {
+			return err
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return fmt.Errorf(Tf("certs-err-conflicts", strings.Join(conflicts, ", ")))
+	}
+
+	return nil
+}
+
+// certDecide entscheidet anhand des letzten Abgleichs, wer die neuere Version hat
+func certDecide(local, remote CertLineage, base string) string {
+	if base != "" {
+		if local.Serial == base {
+			return "pull" // nur auf dem Host erneuert
+		}
+		if remote.Serial == base {
+			return "push" // nur lokal geändert
+		}
+		return "conflict"
+	}
+
+	// ohne bekannten Stand gewinnt nur ein eindeutig späteres Ablaufdatum
+	if remote.NotAfter.After(local.NotAfter) {
+		return "pull"
+	}
+	if local.NotAfter.After(remote.NotAfter) {
+		return "push"
+	}
+
+	return "conflict"
+}
+
+func (cs *CertSync) pullLineage(name string) error {
+	if !cs.DryRun {
+		for _, dir := range []string{"archive", "live", "renewal"} {
+			if err := os.MkdirAll(filepath.Join(cs.Local, dir), 0755); err != nil {
+				return err
+			}
+		}
+	}
+
+	cmds := []string{
+		fmt.Sprintf("rsync -a --delete %s/archive/%s/ %s/archive/%s/", cs.Stage, name, cs.Local, name),
+		fmt.Sprintf("rsync -a --delete %s/live/%s/ %s/live/%s/", cs.Stage, name, cs.Local, name),
+	}
+	renewal := filepath.Join(cs.Stage, "renewal", name+".conf")
+	if _, err := os.Stat(renewal); err == nil {
+		cmds = append(cmds, fmt.Sprintf("rsync -a %s %s/renewal/", renewal, cs.Local))
+	}
+
+	return ShellCmds(cs.DryRun, cmds)
+}
+
+func (cs *CertSync) pushLineages(names []string) error {
+	// mit --relative legt rsync archive/<name> usw. unterhalb von /etc/letsencrypt an
+	var sources []string
+	for _, name := range names {
+		sources = append(sources,
+			fmt.Sprintf("%s/./archive/%s", cs.Local, name),
+			fmt.Sprintf("%s/./live/%s", cs.Local, name))
+		renewal := filepath.Join(cs.Local, "renewal", name+".conf")
+		if _, err := os.Stat(renewal); err == nil {
+			sources = append(sources, fmt.Sprintf("%s/./renewal/%s.conf", cs.Local, name))
+		}
+	}
+
+	rsync := DeployRsync{
+		DryRun:   cs.DryRun,
+		Flags:    []string{"--relative", "--chown=root:root"},
+		Local:    strings.Join(sources, " "),
+		Receiver: cs.RootUser,
+		Remote:   CertRemoteDir + "/",
+	}
+	if !cs.Debug {
+		rsync.Flags = append(rsync.Flags, "--quiet")
+	}
+
+	if err := rsync.Execute(); err != nil {
+		return err
+	}
+	return cs.fixRemotePermissions()
+}
+
+// pushAll überträgt den lokalen Stand komplett, wenn der Host noch nichts hat
+func (cs *CertSync) pushAll() error {
+	if !cs.Push {
+		return nil
+	}
+	if stat, err := os.Stat(cs.Local); err != nil || !stat.IsDir() {
+		return nil
+	}
+
+	rsync := DeployRsync{
+		DryRun:   cs.DryRun,
+		Flags:    []string{"--chown=root:root", "--exclude=/" + CertSyncState},
+		Local:    cs.Local + "/",
+		Receiver: cs.RootUser,
+		Remote:   CertRemoteDir,
+	}
+	if !cs.Debug {
+		rsync.Flags = append(rsync.Flags, "--quiet")
+	}
+
+	if err := rsync.Execute(); err != nil {
+		return err
+	}
+	return cs.fixRemotePermissions()
+}
+
+// fixRemotePermissions: rsync übernimmt die Rechte von hier, der Hook gibt serve wieder Lesezugriff
+func (cs *CertSync) fixRemotePermissions() error {
+	// ssh gibt die Argumente an die Shell des Hosts, dort wirkt ||
+	hookCmd := fmt.Sprintf("ssh %s test ! -x %s || %s", cs.RootUser, CertHookPath, CertHookPath)
+	return ShellCmd(cs.DryRun, hookCmd)
+}
+
+// CertInstallHook legt den certbot-Hook an und wendet ihn sofort an
+func CertInstallHook(dryRun bool) error {
+	content, err := TemplateLoad("certbot-gd-tools-hook.sh")
+	if err != nil {
+		return err
+	}
+	if _, err := FileWriteIfChanged(dryRun, CertHookPath, content, 0755); err != nil {
+		return err
+	}
+
+	return ShellCmd(dryRun, CertHookPath)
+}
+
+// certUpdateSystemIDs übernimmt gd-tools-ids.json aus letsencrypt/ in die lokale Konfiguration
+func certUpdateSystemIDs(dryRun bool) error {
+	if dryRun {
+		return nil
+	}
+
+	systemConfig, err := ReadSystemConfig(false)
+	if err != nil {
+		return nil // noch kein Server-Verzeichnis
+	}
+
+	return systemConfig.Save()
+}
+
+func (cs *CertSync) quiet() string {
+	if cs.Debug {
+		return ""
+	}
+	return " --quiet"
+}
+
+func certRemoteHasDir(rootUser, dir string) (bool, error) {
+	cmd := exec.Command("ssh", rootUser, "test", "-d", dir)
+	err := cmd.Run()
+	if err == nil {
+		return true, nil
+	}
+
+	// test liefert 1, ssh selbst scheitert mit 255
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return false, nil
+	}
+
+	return false, err
+}
+
+// certLoadLineages liest alle Lineages, ein defektes cert.pem ist ein Fehler
+func certLoadLineages(root string) (map[string]CertLineage, error) {
+	return certReadLineages(root, nil)
+}
+
+// certReadLineages meldet defekte Lineages an broken und liest weiter, ohne broken ist es ein Fehler
+func certReadLineages(root string, broken func(name string, err error)) (map[string]CertLineage, error) {
+	lineages := make(map[string]CertLineage)
+
+	entries, err := os.ReadDir(filepath.Join(root, "live"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return lineages, nil
+		}
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue // z.B. live/README
+		}
+
+		certPath := filepath.Join(root, "live", entry.Name(), "cert.pem")
+		cert, err := certParseFile(certPath)
+		if err != nil {
+			if broken == nil {
+				return nil, err
+			}
+			broken(entry.Name(), err)
+			continue
+		}
+
+		lineages[entry.Name()] = CertLineage{
+			Name:     entry.Name(),
+			Serial:   cert.SerialNumber.Text(16),
+			NotAfter: cert.NotAfter,
+		}
+	}
+
+	return lineages, nil
+}
+
+func certParseFile(path string) (*x509.Certificate, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf(Tf("certs-err-parse", path))
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func certLoadState(root string) map[string]string {
+	state := make(map[string]string)
+
+	content, err := os.ReadFile(filepath.Join(root, CertSyncState))
+	if err != nil {
+		return state
+	}
+	if err := json.Unmarshal(content, &state); err != nil {
+		return make(map[string]string)
+	}
+
+	return state
+}
+
+func certSaveState(root string, state map[string]string) error {
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(root, CertSyncState), content, 0644)
+}
+
+func certDate(t time.Time) string {
+	return t.Format("2006-01-02")
+}
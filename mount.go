@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// MountProvider sets up one kind of filesystem below a mount point
+type MountProvider interface {
+	Packages() []string
+	Validate(mount Mount) error
+	Setup(dryRun bool, mount Mount) error
+}
+
+// Registered mount providers (eg. hetzner, raid, nfs)
+var mountProviders = make(map[string]MountProvider)
+
+func RegisterMountProvider(name string, provider MountProvider) {
+	mountProviders[name] = provider
+}
+
+func MountProviderNames() []string {
+	var names []string
+	for name := range mountProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func GetMountProvider(name string) (MountProvider, error) {
+	provider, ok := mountProviders[strings.ToLower(name)]
+	if !ok {
+		msg := Tf("mount-err-unknown-provider", name, strings.Join(MountProviderNames(), ", "))
+		return nil, fmt.Errorf(msg)
+	}
+
+	return provider, nil
+}
+
+// ParseMountSpec reads "provider:identifier[@mountpoint]" as given to setup --mount
+func ParseMountSpec(spec string) (Mount, error) {
+	provider, rest, ok := strings.Cut(spec, ":")
+	if !ok || provider == "" || rest == "" {
+		return Mount{}, fmt.Errorf(Tf("mount-err-invalid-spec", spec))
+	}
+
+	identifier, mountpoint := rest, SystemVarMount
+	if index := strings.LastIndex(rest, "@"); index >= 0 {
+		identifier, mountpoint = rest[:index], rest[index+1:]
+	}
+	if identifier == "" || !strings.HasPrefix(mountpoint, "/") {
+		return Mount{}, fmt.Errorf(Tf("mount-err-invalid-spec", spec))
+	}
+
+	mount := Mount{
+		Provider:   strings.ToLower(provider),
+		Identifier: identifier,
+		Mountpoint: mountpoint,
+	}
+	if _, err := GetMountProvider(mount.Provider); err != nil {
+		return Mount{}, err
+	}
+
+	return mount, nil
+}
+
+// MountIsActive checks the kernel mount table for the target
+func MountIsActive(target string) (bool, error) {
+	mounted, err := checkMountedTargets()
+	if err != nil {
+		return false, err
+	}
+
+	_, ok := mounted[target]
+	return ok, nil
+}
+
+// MountFstabEntry adds the fstab line for target (once) and mounts it
+func MountFstabEntry(dryRun bool, source, target, fsType, options, pass string) error {
+	line := fmt.Sprintf("%s %s %s %s 0 %s", source, target, fsType, options, pass)
+	pattern := fmt.Sprintf(`^\S+\s+%s\s`, regexp.QuoteMeta(target))
+
+	if err := ShellCmd(dryRun, "mkdir -p "+target); err != nil {
+		return err
+	}
+
+	if dryRun {
+		fmt.Printf("[dry] /etc/fstab: '%s'\n", line)
+	} else {
+		if err := FileAddLine("/etc/fstab", pattern, line); err != nil {
+			return err
+		}
+	}
+
+	cmds := []string{
+		"systemctl daemon-reload",
+		"mount " + target,
+	}
+
+	return ShellCmds(dryRun, cmds)
+}
+
+// mountCheckSecret makes sure a credentials or key file exists and is private
+func mountCheckSecret(dryRun bool, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf(Tf("mount-err-secret-missing", path))
+	}
+
+	if info.Mode().Perm()&0o077 != 0 {
+		return ShellCmd(dryRun, "chmod 600 "+path)
+	}
+
+	return nil
+}
+
+func mountOptions(mount Mount, defaults string) string {
+	if mount.Options != "" {
+		return mount.Options
+	}
+	return defaults
+}
+
+func mountFSType(mount Mount, defaults string) string {
+	if mount.FSType != "" {
+		return mount.FSType
+	}
+	return defaults
+}
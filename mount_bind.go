@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func init() {
+	RegisterMountProvider("bind", MountBind{})
+}
+
+// MountBind makes an existing directory visible below the mount point
+type MountBind struct{}
+
+func (MountBind) Packages() []string {
+	return nil
+}
+
+func (MountBind) Validate(mount Mount) error {
+	if mount.Identifier == "" || mount.Identifier[0] != '/' {
+		return fmt.Errorf(Tf("mount-err-bind-source", mount.Identifier))
+	}
+	return nil
+}
+
+func (MountBind) Setup(dryRun bool, mount Mount) error {
+	if active, err := MountIsActive(mount.Mountpoint); err != nil {
+		return err
+	} else if active {
+		fmt.Println(Tf("mount-already-active", mount.Identifier, mount.Mountpoint))
+		return nil
+	}
+
+	if info, err := os.Stat(mount.Identifier); err != nil || !info.IsDir() {
+		return fmt.Errorf(Tf("mount-err-bind-source", mount.Identifier))
+	}
+
+	options := "bind"
+	if mount.Options != "" {
+		options += "," + mount.Options
+	}
+
+	return MountFstabEntry(dryRun, mount.Identifier, mount.Mountpoint, "none", options, "0")
+}
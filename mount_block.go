@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+func init() {
+	RegisterMountProvider("block", MountBlock{})
+}
+
+// MountBlock mounts a formatted block device given as UUID=..., LABEL=... or /dev/...
+type MountBlock struct{}
+
+func (MountBlock) Packages() []string {
+	return nil
+}
+
+func (MountBlock) Validate(mount Mount) error {
+	id := mount.Identifier
+	if !strings.HasPrefix(id, "UUID=") && !strings.HasPrefix(id, "LABEL=") && !strings.HasPrefix(id, "/dev/") {
+		return fmt.Errorf(Tf("mount-err-block-identifier", id))
+	}
+	return nil
+}
+
+func (MountBlock) Setup(dryRun bool, mount Mount) error {
+	if active, err := MountIsActive(mount.Mountpoint); err != nil {
+		return err
+	} else if active {
+		fmt.Println(Tf("mount-already-active", mount.Identifier, mount.Mountpoint))
+		return nil
+	}
+
+	// device names may change between boots, so /dev/... is stored by UUID
+	source := mount.Identifier
+	if strings.HasPrefix(source, "/dev/") {
+		uuid, err := ShellGetDeviceUUID(dryRun, source)
+		if err != nil {
+			return err
+		}
+		source = "UUID=" + uuid
+	}
+
+	fsType := mountFSType(mount, "ext4")
+	options := mountOptions(mount, "defaults,nofail")
+
+	return MountFstabEntry(dryRun, source, mount.Mountpoint, fsType, options, "2")
+}
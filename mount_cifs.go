@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os/user"
+	"strings"
+)
+
+func init() {
+	RegisterMountProvider("cifs", MountCIFS{})
+	RegisterMountProvider("smb", MountCIFS{})
+}
+
+const (
+	MountCIFSCredentials = "/root/.smbcredentials"
+)
+
+// MountCIFS mounts a CIFS/SMB share "//server/share" using a credentials file
+type MountCIFS struct{}
+
+func (MountCIFS) Packages() []string {
+	return []string{"cifs-utils"}
+}
+
+func (MountCIFS) Validate(mount Mount) error {
+	if !strings.HasPrefix(mount.Identifier, "//") || strings.Count(mount.Identifier, "/") < 3 {
+		return fmt.Errorf(Tf("mount-err-cifs-identifier", mount.Identifier))
+	}
+	return nil
+}
+
+func (MountCIFS) Setup(dryRun bool, mount Mount) error {
+	if active, err := MountIsActive(mount.Mountpoint); err != nil {
+		return err
+	} else if active {
+		fmt.Println(Tf("mount-already-active", mount.Identifier, mount.Mountpoint))
+		return nil
+	}
+
+	// username=..., password=... and optionally domain=...
+	credentials := mount.Credentials
+	if credentials == "" {
+		credentials = MountCIFSCredentials
+	}
+	if err := mountCheckSecret(dryRun, credentials); err != nil {
+		return err
+	}
+
+	// mounts runs before the user step, mount -a needs the owner to exist
+	if err := ToolsUserEnsure(dryRun); err != nil {
+		return err
+	}
+	owner := "uid=gd-tools,gid=gd-tools" // only in a dry run on a fresh host
+	if account, err := user.Lookup("gd-tools"); err == nil {
+		owner = fmt.Sprintf("uid=%s,gid=%s", account.Uid, account.Gid)
+	}
+
+	options := mountOptions(mount, "_netdev,nofail,iocharset=utf8,"+owner)
+	options = fmt.Sprintf("credentials=%s,%s", credentials, options)
+
+	return MountFstabEntry(dryRun, mount.Identifier, mount.Mountpoint, "cifs", options, "0")
+}
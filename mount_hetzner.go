@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func init() {
+	RegisterMountProvider("hetzner", MountHetzner{})
+}
+
+// MountHetzner moves a Hetzner Cloud Volume from /mnt/HC_Volume_<id> to the mount point
+type MountHetzner struct{}
+
+func (MountHetzner) Packages() []string {
+	return nil
+}
+
+func (MountHetzner) Validate(mount Mount) error {
+	if mount.Identifier == "" {
+		return fmt.Errorf(Tf("mount-err-missing-identifier", mount.Provider))
+	}
+	return nil
+}
+
+func (MountHetzner) Setup(dryRun bool, mount Mount) error {
+	id, target := mount.Identifier, mount.Mountpoint
+
+	if _, err := os.Stat(target + "/lost+found"); err == nil {
+		fmt.Println(Tf("mount-already-active", id, target))
+		return nil
+	}
+
+	legacy := "/mnt/HC_Volume_" + id
+	if _, err := os.Stat(legacy + "/lost+found"); err == nil {
+		if err := ShellCmd(dryRun, "umount "+legacy); err != nil {
+			return err
+		}
+	} else if os.IsNotExist(err) {
+		return fmt.Errorf(Tf("mount-err-hetzner-missing", legacy, err))
+	}
+
+	if err := ShellCmd(dryRun, "mkdir -p "+target); err != nil {
+		return err
+	}
+	if dryRun {
+		fmt.Println("[dry]", Tf("mount-fstab-move", legacy, target))
+	} else {
+		if _, err := FileReplace("/etc/fstab", legacy+" ", target+" "); err != nil {
+			return err
+		}
+	}
+
+	cmds := []string{
+		"systemctl daemon-reload",
+		"mount -a",
+		"rmdir " + legacy,
+		"chmod 0755 " + target,
+	}
+
+	return ShellCmds(dryRun, cmds)
+}
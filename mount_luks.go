@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+func init() {
+	RegisterMountProvider("luks", MountLUKS{})
+}
+
+// MountLUKS opens a LUKS device with a key file and mounts the mapped filesystem.
+// The device must already be formatted (cryptsetup luksFormat + mkfs), gd-tools never formats.
+type MountLUKS struct{}
+
+func (MountLUKS) Packages() []string {
+	return []string{"cryptsetup"}
+}
+
+func (MountLUKS) Validate(mount Mount) error {
+	id := mount.Identifier
+	if !strings.HasPrefix(id, "UUID=") && !strings.HasPrefix(id, "/dev/") {
+		return fmt.Errorf(Tf("mount-err-block-identifier", id))
+	}
+	return nil
+}
+
+func (MountLUKS) Setup(dryRun bool, mount Mount) error {
+	if active, err := MountIsActive(mount.Mountpoint); err != nil {
+		return err
+	} else if active {
+		fmt.Println(Tf("mount-already-active", mount.Identifier, mount.Mountpoint))
+		return nil
+	}
+
+	name := luksMapperName(mount.Mountpoint)
+	keyFile := mount.KeyFile
+	if keyFile == "" {
+		keyFile = fmt.Sprintf("/root/.luks-%s.key", name)
+	}
+	if err := mountCheckSecret(dryRun, keyFile); err != nil {
+		return err
+	}
+
+	source := mount.Identifier
+	if strings.HasPrefix(source, "/dev/") {
+		uuid, err := ShellGetDeviceUUID(dryRun, source)
+		if err != nil {
+			return err
+		}
+		source = "UUID=" + uuid
+	}
+
+	line := fmt.Sprintf("%s %s %s luks,nofail", name, source, keyFile)
+	if dryRun {
+		fmt.Printf("[dry] /etc/crypttab: '%s'\n", line)
+	} else {
+		pattern := fmt.Sprintf(`^%s\s`, regexp.QuoteMeta(name))
+		if err := FileAddLine("/etc/crypttab", pattern, line); err != nil {
+			return err
+		}
+	}
+
+	mapper := filepath.Join("/dev/mapper", name)
+	if _, err := os.Stat(mapper); err != nil {
+		device := strings.TrimPrefix(source, "UUID=")
+		if strings.HasPrefix(source, "UUID=") {
+			device = "/dev/disk/by-uuid/" + device
+		}
+		open := fmt.Sprintf("cryptsetup open --key-file %s %s %s", keyFile, device, name)
+		if err := ShellCmd(dryRun, open); err != nil {
+			return err
+		}
+	}
+
+	fsType := mountFSType(mount, "ext4")
+	options := mountOptions(mount, "defaults,nofail")
+
+	return MountFstabEntry(dryRun, mapper, mount.Mountpoint, fsType, options, "2")
+}
+
+// luksMapperName derives "gd-var-gd-tools" from "/var/gd-tools"
+func luksMapperName(target string) string {
+	return "gd-" + strings.ReplaceAll(strings.Trim(target, "/"), "/", "-")
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+func init() {
+	RegisterMountProvider("nfs", MountNFS{})
+}
+
+// MountNFS mounts an NFS export given as "server:/export"
+type MountNFS struct{}
+
+func (MountNFS) Packages() []string {
+	return []string{"nfs-common"}
+}
+
+func (MountNFS) Validate(mount Mount) error {
+	server, export, ok := strings.Cut(mount.Identifier, ":")
+	if !ok || server == "" || !strings.HasPrefix(export, "/") {
+		return fmt.Errorf(Tf("mount-err-nfs-identifier", mount.Identifier))
+	}
+	return nil
+}
+
+func (MountNFS) Setup(dryRun bool, mount Mount) error {
+	if active, err := MountIsActive(mount.Mountpoint); err != nil {
+		return err
+	} else if active {
+		fmt.Println(Tf("mount-already-active", mount.Identifier, mount.Mountpoint))
+		return nil
+	}
+
+	fsType := mountFSType(mount, "nfs")
+	options := mountOptions(mount, "defaults,_netdev,nofail")
+
+	return MountFstabEntry(dryRun, mount.Identifier, mount.Mountpoint, fsType, options, "0")
+}
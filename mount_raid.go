@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func init() {
+	RegisterMountProvider("raid", MountRAID{})
+}
+
+// MountRAID mounts an (already assembled) software RAID device by its UUID
+type MountRAID struct{}
+
+func (MountRAID) Packages() []string {
+	return []string{"mdadm"}
+}
+
+func (MountRAID) Validate(mount Mount) error {
+	if mount.Identifier == "" {
+		return fmt.Errorf(Tf("mount-err-missing-identifier", mount.Provider))
+	}
+	return nil
+}
+
+func (MountRAID) Setup(dryRun bool, mount Mount) error {
+	target := mount.Mountpoint
+
+	if _, err := os.Stat(target + "/lost+found"); err == nil {
+		fmt.Println(Tf("mount-already-active", mount.Identifier, target))
+		return nil
+	}
+
+	uuid, err := ShellGetDeviceUUID(dryRun, mount.Identifier)
+	if err != nil {
+		return err
+	}
+
+	source := "UUID=" + uuid
+	fsType := mountFSType(mount, "ext4")
+	options := mountOptions(mount, "defaults,nofail")
+
+	return MountFstabEntry(dryRun, source, target, fsType, options, "0")
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"os/user"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -12,6 +13,9 @@ import (
 const (
 	ProdDataRoot   = "/var/gd-tools"
 	LetsEncryptDir = "letsencrypt"
+
+	ProjectsDir     = "projects"         // below the home of gd-tools, the target of deploy
+	ProjectsRootEnv = "GDTOOLS_PROJECTS" // overrides the project root on a host
 )
 
 type Config struct {
@@ -35,18 +39,22 @@ type Project struct {
 	Compose []byte
 }
 
+// GetProjectRoot is the working directory in dev, on a host the tree deploy copies to ~gd-tools/projects.
+// It never depends on the working directory there, serve runs in /var/gd-tools.
 func GetProjectRoot() (string, error) {
-	localDir, err := os.Getwd()
-	if err != nil {
-		return "", err
+	if CheckEnv("dev") {
+		return os.Getwd()
 	}
 
-	if CheckEnv("dev") {
-		return localDir, nil
+	if root := os.Getenv(ProjectsRootEnv); root != "" {
+		return root, nil
+	}
+	account, err := user.Lookup("gd-tools")
+	if err != nil {
+		return "", err
 	}
 
-	// TODO prepend $HOMEDIR
-	return "projects", nil
+	return filepath.Join(account.HomeDir, ProjectsDir), nil
 }
 
 func ProjectLoadAll() ([]*Project, error) {
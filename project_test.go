@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// serve runs in /var/gd-tools, the projects must not be looked up relative to it
+func TestProjectRootOutsideWorkingDir(t *testing.T) {
+	if CheckEnv("dev") {
+		t.Skip("dev host, the project root is the working directory")
+	}
+
+	root := t.TempDir()
+	project := filepath.Join(root, "001-web-demo")
+	if err := os.MkdirAll(project, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(project, "config.json"), []byte(`{"is_enabled": true}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(ProjectsRootEnv, root)
+
+	workDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(workDir) })
+
+	found, err := projectFind("001-web-demo")
+	if err != nil {
+		t.Fatalf("projectFind: %v", err)
+	}
+	if found == nil {
+		t.Fatal("projectFind: 001-web-demo not found")
+	}
+	if path, _ := found.GetPath(); path != project {
+		t.Errorf("GetPath = %q, want %q", path, project)
+	}
+
+	// without Docker the containers are missing, the projects must not
+	views, _, err := ProjectViews(context.Background())
+	if err != nil {
+		t.Fatalf("ProjectViews: %v", err)
+	}
+	if len(views) != 1 || !views[0].Enabled {
+		t.Errorf("ProjectViews = %+v, want 001-web-demo enabled", views)
+	}
+
+	// the API lists and finds the project like the dashboard
+	for name, want := range map[string]int{"": http.StatusOK, "001-web-demo": http.StatusOK, "002-web-gone": http.StatusNotFound} {
+		request := httptest.NewRequest(http.MethodGet, "/api/v1/projects/"+name, nil)
+		request.SetPathValue("name", name)
+		recorder := httptest.NewRecorder()
+		APIProjectsHandler(recorder, request)
+		if recorder.Code != want {
+			t.Errorf("APIProjectsHandler(%q) = %d, want %d", name, recorder.Code, want)
+		}
+	}
+
+	// /healthz may complain about Docker, but never about loading the projects
+	if check := healthProjects(context.Background()); check.Message == "cannot load projects" {
+		t.Errorf("healthProjects = %+v", check)
+	}
+}
@@ -19,8 +19,10 @@ import (
 var wwwFS embed.FS
 
 type ServeConfig struct {
-	SysAdmin string `json:"sys_admin"`
-	Password string `json:"password"`
+	Schema int `json:"schema"` // layout of this file (see ConfigSchema)
+
+	SysAdmin string      `json:"sys_admin"`
+	Users    []ServeUser `json:"users"` // see "serve user add"
 
 	Address string `json:"address"`
 
@@ -28,20 +30,29 @@ type ServeConfig struct {
 	ProgLink   string `json:"prog_link"`
 	ImprintURL string `json:"imprint_url"`
 	ProtectURL string `json:"protect_url"`
-}
 
-var (
-	serveMux *http.ServeMux
+	SessionKey   string `json:"session_key,omitempty"`   // hex, see "serve passwd"
+	SessionHours int    `json:"session_hours,omitempty"` // default SessionDefaultHours
 
-	serveLayoutContent string
-	serveHomeContent   string
-	serveStatusContent string
-)
+	MetricsToken string `json:"metrics_token,omitempty"` // empty: /metrics only from localhost
+
+	LogsSearchLines int `json:"logs_search_lines,omitempty"` // docker logs searched per request, default LogsSearchDefault
+
+	Tokens []ServeToken `json:"tokens,omitempty"` // for /api/v1/, see "serve token create"
+
+	Health *HealthConfig `json:"health,omitempty"` // thresholds of /healthz (defaults if missing)
+}
+
+var serveMux *http.ServeMux
 
 type ServePage struct {
 	Title   string
 	Content template.HTML
 
+	User      string // empty before the login
+	Role      string
+	CSRFToken string // for the logout button
+
 	ServeConfig
 }
 
@@ -53,11 +64,32 @@ func RunWebServer() error {
 	serveMux.Handle("/static/", http.StripPrefix("/", staticServer))
 
 	serveMux.HandleFunc("/", HomeHandler)
-	serveMux.HandleFunc("/status", BasicAuthMiddleware(StatusHandler))
+	serveMux.HandleFunc("/login", LoginHandler)
+	serveMux.HandleFunc("/logout", LogoutHandler)
+	serveMux.HandleFunc("/status", RoleMiddleware(RoleViewer, StatusHandler))
+	serveMux.HandleFunc("/ws", RoleMiddleware(RoleViewer, WebSocketHandler.ServeHTTP))
+	serveMux.HandleFunc("/projects", RoleMiddleware(RoleViewer, ProjectsHandler))
+	serveMux.HandleFunc("/projects/action", RoleMiddleware(RoleOperator, ProjectActionHandler))
+	serveMux.HandleFunc("/logs", RoleMiddleware(RoleViewer, LogsHandler))
+	serveMux.HandleFunc("/logs/view", RoleMiddleware(RoleViewer, LogsViewHandler))
+	serveMux.HandleFunc("/logs/tail", RoleMiddleware(RoleViewer, LogsTailHandler))
+	serveMux.HandleFunc("/logs/docker", RoleMiddleware(RoleViewer, LogsDockerHandler))
+	serveMux.HandleFunc("/users", RoleMiddleware(RoleAdmin, UsersHandler))
+	serveMux.HandleFunc("/metrics", MetricsHandler)
+	serveMux.HandleFunc("/healthz", HealthHandler)
+
+	serveMux.HandleFunc("/api/v1/", APINotFoundHandler)
+	serveMux.HandleFunc("GET /api/v1/openapi.json", APIOpenAPIHandler)
+	serveMux.HandleFunc("GET /api/v1/status", APIMiddleware(ScopeRead, APIStatusHandler))
+	serveMux.HandleFunc("GET /api/v1/projects", APIMiddleware(ScopeRead, APIProjectsHandler))
+	serveMux.HandleFunc("GET /api/v1/projects/{name}", APIMiddleware(ScopeRead, APIProjectsHandler))
+	serveMux.HandleFunc("POST /api/v1/projects/{name}/{action}", APIMiddleware(ScopeWrite, APIActionHandler))
+	serveMux.HandleFunc("GET /api/v1/containers", APIMiddleware(ScopeRead, APIContainersHandler))
+	serveMux.HandleFunc("GET /api/v1/certificates", APIMiddleware(ScopeRead, APICertificatesHandler))
 
 	webServer := &http.Server{
-		Handler:      LocaleMiddleware(serveMux),
-		Addr:         serveConfig.Address,
+		Handler:      LocaleMiddleware(MetricsMiddleware(serveMux)),
+		Addr:         ServeCurrent().Config.Address,
 		WriteTimeout: 15 * time.Second,
 		ReadTimeout:  15 * time.Second,
 	}
@@ -65,9 +97,22 @@ func RunWebServer() error {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	// systemctl reload sends SIGHUP, see ExecReload in the unit
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+
+	go statusHub.Run(ctx)
 	go ListenRoutine(webServer)
 
-	<-ctx.Done()
+	for running := true; running; {
+		select {
+		case <-reload:
+			ServeReload()
+		case <-ctx.Done():
+			running = false
+		}
+	}
 	log.Printf("INFO: RunWebServer: interrupted")
 
 	if err := webServer.Shutdown(context.TODO()); err != nil {
@@ -136,13 +181,18 @@ func (p ServePage) Render(w http.ResponseWriter, r *http.Request) {
 	if p.Title != "" {
 		p.Title += " - "
 	}
-	p.ServeConfig = serveConfig
+	p.ServeConfig = ServeCurrent().Config
+	if session := SessionGet(r); session != nil {
+		p.User = session.User
+		p.Role = SessionRole(r)
+		p.CSRFToken = session.CSRFToken()
+	}
 
 	parsedLayout, err := template.New("app").Funcs(template.FuncMap{
 		"T": func(msg string, args ...interface{}) string {
 			return WebT(r, msg, args...)
 		},
-	}).Parse(serveLayoutContent)
+	}).Parse(ServeCurrent().Page("application.html"))
 
 	if err != nil {
 		http.Error(w, "Internal error (app-parse)", 500)
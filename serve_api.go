@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	APITokenPrefix = "gdt_"
+	ScopeRead      = "read"
+	ScopeWrite     = "write" // includes read
+)
+
+// ServeToken is an API token, only the hash of its secret is stored
+type ServeToken struct {
+	ID      string    `json:"id"` // also part of the token, to find it without trying all hashes
+	Name    string    `json:"name"`
+	Scope   string    `json:"scope"`
+	Hash    string    `json:"hash"` // sha256 of the secret, hex
+	Created time.Time `json:"created"`
+}
+
+type apiTokenContextKey struct{}
+
+// APIError is the body of every error answer below /api/v1/
+type APIError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// APIProject adds the health to ProjectView
+type APIProject struct {
+	ProjectView
+	Health string `json:"health"`
+}
+
+// APIStatus is the answer of /api/v1/status
+type APIStatus struct {
+	Time          time.Time `json:"time"`
+	DockerError   string    `json:"docker_error,omitempty"`
+	Containers    int       `json:"containers"`
+	Running       int       `json:"running"`
+	Updates       int       `json:"updates"`
+	Security      int       `json:"security_updates"`
+	RebootNeeded  bool      `json:"reboot_required"`
+	UpdatesError  string    `json:"updates_error,omitempty"`
+	Projects      int       `json:"projects"`
+	ProjectsError string    `json:"projects_error,omitempty"`
+}
+
+// APICertificate is one Let's Encrypt lineage
+type APICertificate struct {
+	Name     string    `json:"name"`
+	Serial   string    `json:"serial"`
+	NotAfter time.Time `json:"not_after"`
+	DaysLeft int       `json:"days_left"`
+}
+
+// APIActionResult is the answer of a start, stop or restart
+type APIActionResult struct {
+	Project string `json:"project"`
+	Action  string `json:"action"`
+	Output  string `json:"output"`
+}
+
+// APITokenNew returns the token to show once and its entry for the config
+func APITokenNew(name, scope string) (string, ServeToken) {
+	id := hex.EncodeToString(sessionRandom(4))
+	secret := base64.RawURLEncoding.EncodeToString(sessionRandom(32))
+
+	return APITokenPrefix + id + "_" + secret, ServeToken{
+		ID:      id,
+		Name:    name,
+		Scope:   scope,
+		Hash:    apiTokenHash(secret),
+		Created: time.Now().UTC().Truncate(time.Second),
+	}
+}
+
+// the secret has 256 random bits, a fast hash is enough
+func apiTokenHash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// APITokenFind checks a bearer token against the config
+func APITokenFind(token string) *ServeToken {
+	rest, ok := strings.CutPrefix(token, APITokenPrefix)
+	if !ok {
+		return nil
+	}
+	id, secret, ok := strings.Cut(rest, "_")
+	if !ok {
+		return nil
+	}
+
+	hash := apiTokenHash(secret)
+	tokens := ServeCurrent().Config.Tokens
+	for index := range tokens {
+		entry := &tokens[index]
+		if entry.ID == id && subtle.ConstantTimeCompare([]byte(entry.Hash), []byte(hash)) == 1 {
+			return entry
+		}
+	}
+	return nil
+}
+
+// APIMiddleware wants a bearer token with at least the given scope
+func APIMiddleware(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gd-tools"`)
+			apiError(w, http.StatusUnauthorized, "missing bearer token")
+			return
+		}
+		token := APITokenFind(strings.TrimSpace(given))
+		if token == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gd-tools", error="invalid_token"`)
+			apiError(w, http.StatusUnauthorized, "invalid token")
+			return
+		}
+		if scope == ScopeWrite && token.Scope != ScopeWrite {
+			apiError(w, http.StatusForbidden, "token scope does not allow this")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), apiTokenContextKey{}, token)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+func apiJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		log.Println("WARN: apiJSON:", err)
+	}
+}
+
+func apiError(w http.ResponseWriter, code int, message string) {
+	var body APIError
+	body.Error.Code = code
+	body.Error.Message = message
+	apiJSON(w, code, body)
+}
+
+// APINotFoundHandler keeps unknown paths below /api/v1/ in JSON
+func APINotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	apiError(w, http.StatusNotFound, "no such endpoint")
+}
+
+func APIProjectsHandler(w http.ResponseWriter, r *http.Request) {
+	views, dockerErr, err := ProjectViews(r.Context())
+	if err != nil {
+		log.Println("ERROR: ProjectLoadAll:", err)
+		apiError(w, http.StatusInternalServerError, "cannot load projects")
+		return
+	}
+	if dockerErr != nil {
+		log.Println("WARN: StatusSnapshot:", dockerErr)
+	}
+
+	projects := []APIProject{}
+	for _, view := range views {
+		if name := r.PathValue("name"); name != "" && name != view.Name {
+			continue
+		}
+		projects = append(projects, APIProject{ProjectView: view, Health: view.Health()})
+	}
+
+	if r.PathValue("name") != "" {
+		if len(projects) == 0 {
+			apiError(w, http.StatusNotFound, "no such project")
+			return
+		}
+		apiJSON(w, http.StatusOK, projects[0])
+		return
+	}
+	apiJSON(w, http.StatusOK, projects)
+}
+
+func APIStatusHandler(w http.ResponseWriter, r *http.Request) {
+	status := APIStatus{Time: time.Now().UTC()}
+
+	containers, err := StatusSnapshot(r.Context())
+	if err != nil {
+		status.DockerError = err.Error()
+	}
+	status.Containers = len(containers)
+	for _, container := range containers {
+		if container.State == "running" {
+			status.Running++
+		}
+	}
+
+	updates := UpdatesGetInfo()
+	status.Updates = updates.Pending
+	status.Security = updates.Security
+	status.RebootNeeded = updates.RebootRequired
+	status.UpdatesError = updates.Error
+
+	if projects, err := ProjectLoadAll(); err != nil {
+		status.ProjectsError = err.Error()
+	} else {
+		status.Projects = len(projects)
+	}
+
+	apiJSON(w, http.StatusOK, status)
+}
+
+func APIContainersHandler(w http.ResponseWriter, r *http.Request) {
+	containers, err := StatusSnapshot(r.Context())
+	if err != nil {
+		apiError(w, http.StatusBadGateway, "docker: "+err.Error())
+		return
+	}
+	if containers == nil {
+		containers = []StatusContainer{}
+	}
+
+	apiJSON(w, http.StatusOK, containers)
+}
+
+func APICertificatesHandler(w http.ResponseWriter, r *http.Request) {
+	lineages, err := certLoadLineages(CertRemoteDir)
+	if errors.Is(err, fs.ErrPermission) {
+		log.Println("WARN: certLoadLineages:", err)
+		apiError(w, http.StatusServiceUnavailable, "certificates are not readable for the service user, run gd-tools system --only collect")
+		return
+	}
+	if err != nil {
+		log.Println("WARN: certLoadLineages:", err)
+		apiError(w, http.StatusInternalServerError, "cannot read certificates")
+		return
+	}
+
+	certificates := []APICertificate{}
+	for _, lineage := range lineages {
+		certificates = append(certificates, APICertificate{
+			Name:     lineage.Name,
+			Serial:   lineage.Serial,
+			NotAfter: lineage.NotAfter.UTC(),
+			DaysLeft: int(time.Until(lineage.NotAfter).Hours() / 24),
+		})
+	}
+	sort.Slice(certificates, func(i, j int) bool {
+		return certificates[i].Name < certificates[j].Name
+	})
+
+	apiJSON(w, http.StatusOK, certificates)
+}
+
+// APIActionHandler runs start, stop or restart, like the button on /projects
+func APIActionHandler(w http.ResponseWriter, r *http.Request) {
+	result := APIActionResult{
+		Project: r.PathValue("name"),
+		Action:  r.PathValue("action"),
+	}
+	if _, ok := projectActions[result.Action]; !ok {
+		apiError(w, http.StatusBadRequest, "unknown action, use start, stop or restart")
+		return
+	}
+
+	project, err := projectFind(result.Project)
+	if err != nil {
+		log.Println("ERROR: ProjectLoadAll:", err)
+		apiError(w, http.StatusInternalServerError, "cannot load projects")
+		return
+	}
+	if project == nil {
+		apiError(w, http.StatusNotFound, "no such project")
+		return
+	}
+
+	// docker compose may take longer than the server's WriteTimeout
+	deadline := time.Now().Add(ProjectActionTimeout + time.Minute)
+	http.NewResponseController(w).SetWriteDeadline(deadline)
+
+	token, _ := r.Context().Value(apiTokenContextKey{}).(*ServeToken)
+	log.Printf("INFO: token %s (%s): %s %s", token.ID, token.Name, result.Action, result.Project)
+
+	result.Output, err = projectRun(project, result.Action)
+	if err != nil {
+		log.Printf("WARN: %s %s: %v", result.Action, result.Project, err)
+		apiError(w, http.StatusInternalServerError, result.Action+" failed: "+err.Error()+"\n"+result.Output)
+		return
+	}
+
+	apiJSON(w, http.StatusOK, result)
+}
+
+// APIOpenAPIHandler serves the description of this API from the binary
+func APIOpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(ServeCurrent().Page("openapi.json")))
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+)
+
+const (
+	CSRFCookieName = "gd_csrf"
+	CSRFFieldName  = "csrf_token"
+)
+
+// CSRFToken returns the token of the session, before the login the token of this
+// browser (double submit cookie, set on first use). Forms send it back in CSRFFieldName.
+func CSRFToken(w http.ResponseWriter, r *http.Request) string {
+	if session := SessionGet(r); session != nil {
+		return session.CSRFToken()
+	}
+
+	if cookie, err := r.Cookie(CSRFCookieName); err == nil && len(cookie.Value) == 64 {
+		return cookie.Value
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err) // no randomness, no security
+	}
+	token := hex.EncodeToString(buf)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     CSRFCookieName,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   ServeIsHTTPS(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	return token
+}
+
+// CSRFCheck accepts a POST only from our own pages
+func CSRFCheck(r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		return false
+	}
+
+	// browsers send Origin on POST, older ones at least Referer
+	source := r.Header.Get("Origin")
+	if source == "" {
+		source = r.Header.Get("Referer")
+	}
+	if parsed, err := url.Parse(source); err != nil || parsed.Host != r.Host {
+		return false
+	}
+
+	form := r.PostFormValue(CSRFFieldName)
+	if session := SessionGet(r); session != nil {
+		return session.CSRFCheck(form)
+	}
+
+	cookie, err := r.Cookie(CSRFCookieName)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(form)) == 1
+}
+
+// ServeIsHTTPS also looks at the header set by the nginx proxy
+func ServeIsHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
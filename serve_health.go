@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	HealthDefaultMinFreeMB      = 1024
+	HealthDefaultMinFreePercent = 5
+	HealthDefaultCertDays       = 7
+
+	// an unauthenticated endpoint must not start a Docker round trip per request
+	HealthCacheTime     = 10 * time.Second
+	HealthDockerTimeout = 3 * time.Second
+)
+
+// HealthConfig holds the thresholds of /healthz, missing values use the defaults
+type HealthConfig struct {
+	MinFreeMB      int `json:"min_free_mb,omitempty"`      // on /var/gd-tools, default 1024
+	MinFreePercent int `json:"min_free_percent,omitempty"` // on /var/gd-tools, default 5
+	CertDays       int `json:"cert_days,omitempty"`        // certificates must be valid longer, default 7
+}
+
+// HealthCheck is one part of the answer
+type HealthCheck struct {
+	OK      bool   `json:"ok"`
+	Unknown bool   `json:"unknown,omitempty"` // could not be checked, does not fail the report
+	Message string `json:"message,omitempty"`
+}
+
+// HealthReport is the body of /healthz, 200 if all checks are ok, otherwise 503
+type HealthReport struct {
+	Status string                 `json:"status"` // "ok" or "fail"
+	Time   time.Time              `json:"time"`
+	Checks map[string]HealthCheck `json:"checks"`
+}
+
+var (
+	healthMutex  sync.Mutex
+	healthReport *HealthReport
+)
+
+func (hc *HealthConfig) GetMinFreeMB() int {
+	if hc == nil || hc.MinFreeMB == 0 {
+		return HealthDefaultMinFreeMB
+	}
+	return hc.MinFreeMB
+}
+
+func (hc *HealthConfig) GetMinFreePercent() int {
+	if hc == nil || hc.MinFreePercent == 0 {
+		return HealthDefaultMinFreePercent
+	}
+	return hc.MinFreePercent
+}
+
+func (hc *HealthConfig) GetCertDays() int {
+	if hc == nil || hc.CertDays == 0 {
+		return HealthDefaultCertDays
+	}
+	return hc.CertDays
+}
+
+func (hc *HealthConfig) Validate() error {
+	if hc.MinFreeMB < 0 || hc.CertDays < 0 || hc.MinFreePercent < 0 || hc.MinFreePercent > 100 {
+		return fmt.Errorf(T("config-err-health"))
+	}
+	return nil
+}
+
+// HealthHandler answers the uptime checker, without login
+func HealthHandler(w http.ResponseWriter, r *http.Request) {
+	healthMutex.Lock()
+	if healthReport == nil || time.Since(healthReport.Time) > HealthCacheTime {
+		// the report is shared, a client hanging up must not spoil it
+		ctx, cancel := context.WithTimeout(context.Background(), HealthCacheTime)
+		healthReport = healthCollect(ctx)
+		cancel()
+	}
+	report := healthReport
+	healthMutex.Unlock()
+
+	code := http.StatusOK
+	if report.Status != "ok" {
+		code = http.StatusServiceUnavailable
+	}
+	apiJSON(w, code, report)
+}
+
+func healthCollect(ctx context.Context) *HealthReport {
+	report := &HealthReport{
+		Status: "ok",
+		Time:   time.Now().UTC(),
+		Checks: map[string]HealthCheck{
+			"docker":       healthDocker(),
+			"projects":     healthProjects(ctx),
+			"disk":         healthDisk(ProdDataRoot),
+			"certificates": healthCertificates(),
+		},
+	}
+
+	for name, check := range report.Checks {
+		if check.OK {
+			continue
+		}
+		if !check.Unknown {
+			report.Status = "fail"
+		}
+		log.Printf("WARN: healthz: %s: %s", name, check.Message)
+	}
+	return report
+}
+
+func healthDocker() HealthCheck {
+	if !IsDockerAvailable(HealthDockerTimeout) {
+		return HealthCheck{Message: "no answer to ping"}
+	}
+	return HealthCheck{OK: true}
+}
+
+// healthProjects wants all containers of every enabled project running
+func healthProjects(ctx context.Context) HealthCheck {
+	views, dockerErr, err := ProjectViews(ctx)
+	if err != nil {
+		return HealthCheck{Message: "cannot load projects"}
+	}
+	if dockerErr != nil {
+		return HealthCheck{Message: "no container list from docker"}
+	}
+
+	var failed []string
+	enabled := 0
+	for _, view := range views {
+		if !view.Enabled {
+			continue
+		}
+		enabled++
+		if view.Health() != "running" {
+			failed = append(failed, fmt.Sprintf("%s %d/%d", view.Name, view.Running, len(view.Containers)))
+		}
+	}
+	if len(failed) > 0 {
+		return HealthCheck{Message: "not running: " + strings.Join(failed, ", ")}
+	}
+	return HealthCheck{OK: true, Message: fmt.Sprintf("%d enabled", enabled)}
+}
+
+func healthDisk(path string) HealthCheck {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return HealthCheck{Message: err.Error()}
+	}
+
+	free := stat.Bavail * uint64(stat.Bsize)
+	total := stat.Blocks * uint64(stat.Bsize)
+	freeMB := int(free / (1024 * 1024))
+	percent := 100
+	if total > 0 {
+		percent = int(free * 100 / total)
+	}
+
+	health := ServeCurrent().Config.Health
+	return HealthCheck{
+		OK:      freeMB >= health.GetMinFreeMB() && percent >= health.GetMinFreePercent(),
+		Message: fmt.Sprintf("%d MB free (%d%%)", freeMB, percent),
+	}
+}
+
+// healthCertificates names the certificates that expire within the threshold
+func healthCertificates() HealthCheck {
+	lineages, err := certLoadLineages(CertRemoteDir)
+	if errors.Is(err, fs.ErrPermission) {
+		// a wrong directory mode is no outage, the hook of "gd-tools system --only collect" fixes it
+		return HealthCheck{Unknown: true, Message: "unreadable for the service user"}
+	}
+	if err != nil {
+		return HealthCheck{Message: "cannot read certificates"}
+	}
+
+	minDays := ServeCurrent().Config.Health.GetCertDays()
+	var expiring []string
+	for _, lineage := range lineages {
+		days := int(time.Until(lineage.NotAfter).Hours() / 24)
+		if days < minDays {
+			expiring = append(expiring, fmt.Sprintf("%s %dd", lineage.Name, days))
+		}
+	}
+	if len(expiring) > 0 {
+		return HealthCheck{Message: "expiring: " + strings.Join(expiring, ", ")}
+	}
+	return HealthCheck{OK: true, Message: fmt.Sprintf("%d valid", len(lineages))}
+}
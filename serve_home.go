@@ -5,7 +5,7 @@ import (
 )
 
 func HomeHandler(w http.ResponseWriter, r *http.Request) {
-	content, err := ServeParsePage(w, r, serveHomeContent, serveConfig)
+	content, err := ServeParsePage(w, r, ServeCurrent().Page("home.html"), ServeCurrent().Config)
 	if err != nil {
 		return
 	}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	LoginMaxFailures = 5                // per IP and per user within LoginWindow
+	LoginWindow      = 15 * time.Minute // failures older than this are forgotten
+	LoginLockout     = 15 * time.Minute
+	LoginPendingTime = 5 * time.Minute // to enter the TOTP code after the password
+)
+
+// LoginPage is the data of the login form
+type LoginPage struct {
+	ServeConfig
+	CSRFToken string
+	User      string
+	Next      string
+	Error     string
+	Pending   string // set while the TOTP code is asked for
+}
+
+// loginPending is a login with correct password that waits for the second factor
+type loginPending struct {
+	User     string
+	Secret   string // decrypted TOTP secret, only in memory
+	Next     string
+	Expires  time.Time
+	Attempts int
+}
+
+var (
+	loginPendingMutex sync.Mutex
+	loginPendings     = make(map[string]*loginPending)
+)
+
+type loginFailures struct {
+	Count       int
+	First       time.Time
+	LockedUntil time.Time
+}
+
+// LoginThrottle counts failed logins and locks the key (IP or user) for a while
+type LoginThrottle struct {
+	mutex    sync.Mutex
+	failures map[string]*loginFailures
+}
+
+var loginThrottle = &LoginThrottle{failures: make(map[string]*loginFailures)}
+
+// loginDummyHash keeps the answer time the same for unknown users
+var loginDummyHash = sync.OnceValue(func() string {
+	hash, _ := bcrypt.GenerateFromPassword(sessionRandom(16), bcrypt.DefaultCost)
+	return string(hash)
+})
+
+// Locked returns how long the key is still locked out
+func (lt *LoginThrottle) Locked(key string) time.Duration {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+
+	entry := lt.failures[key]
+	if entry == nil {
+		return 0
+	}
+	return time.Until(entry.LockedUntil)
+}
+
+func (lt *LoginThrottle) Fail(key string) {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+
+	now := time.Now()
+	for other, entry := range lt.failures {
+		if now.Sub(entry.First) > LoginWindow && now.After(entry.LockedUntil) {
+			delete(lt.failures, other)
+		}
+	}
+
+	entry := lt.failures[key]
+	if entry == nil {
+		entry = &loginFailures{First: now}
+		lt.failures[key] = entry
+	}
+	entry.Count++
+	if entry.Count >= LoginMaxFailures {
+		entry.LockedUntil = now.Add(LoginLockout)
+		entry.Count = 0
+		entry.First = now
+		log.Printf("WARN: login locked for %s until %s", key, entry.LockedUntil.Format(time.TimeOnly))
+	}
+}
+
+func (lt *LoginThrottle) Reset(key string) {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+
+	delete(lt.failures, key)
+}
+
+// LoginHandler shows the form on GET and checks the credentials on POST
+func LoginHandler(w http.ResponseWriter, r *http.Request) {
+	data := LoginPage{
+		ServeConfig: ServeCurrent().Config,
+		User:        r.FormValue("user"),
+		Next:        loginNext(r.FormValue("next")),
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if SessionGet(r) != nil {
+			http.Redirect(w, r, data.Next, http.StatusSeeOther)
+			return
+		}
+	case http.MethodPost:
+		if !CSRFCheck(r) {
+			http.Error(w, "Forbidden (csrf)", http.StatusForbidden)
+			return
+		}
+		var ok bool
+		if pending := r.PostFormValue("pending"); pending != "" {
+			ok = loginCheckTOTP(r, pending, &data)
+		} else {
+			ok = loginCheck(r, &data)
+		}
+		if ok {
+			log.Printf("INFO: %s: login from %s", data.User, ServeClientIP(r))
+			SessionStart(w, r, data.User)
+			http.Redirect(w, r, data.Next, http.StatusSeeOther)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data.CSRFToken = CSRFToken(w, r)
+	content, err := ServeParsePage(w, r, ServeCurrent().Page("login.html"), data)
+	if err != nil {
+		return
+	}
+
+	page := ServePage{
+		Title:   T("web-login-title"),
+		Content: content,
+	}
+
+	page.Render(w, r)
+}
+
+// loginCheck verifies user and password unless the IP or the user is locked out
+func loginCheck(r *http.Request, data *LoginPage) bool {
+	ipKey := "ip:" + ServeClientIP(r)
+	userKey := "user:" + strings.ToLower(data.User)
+
+	locked := max(loginThrottle.Locked(ipKey), loginThrottle.Locked(userKey))
+	if locked > 0 {
+		minutes := int(locked.Minutes()) + 1
+		data.Error = fmt.Sprintf(WebT(r, "web-login-locked"), minutes)
+		return false
+	}
+
+	// one lookup, a reload in between must not change the user
+	user := ServeCurrent().Config.FindUser(data.User)
+	hash, known := "", user != nil
+	if known {
+		hash = user.Password
+	}
+	if hash == "" || hash == "TODO" {
+		hash, known = loginDummyHash(), false
+	}
+	if !checkPasswordHash(r.PostFormValue("password"), hash) || !known {
+		log.Printf("WARN: %s: login failed from %s", data.User, ServeClientIP(r))
+		loginThrottle.Fail(ipKey)
+		loginThrottle.Fail(userKey)
+		data.Error = WebT(r, "web-login-failed")
+		return false
+	}
+
+	loginThrottle.Reset(ipKey)
+	loginThrottle.Reset(userKey)
+	data.User = user.Name // as written in the config
+
+	totp := user.TOTP
+	if totp == nil {
+		return true
+	}
+	secret, err := TOTPDecrypt(totp.Secret, r.PostFormValue("password"))
+	if err != nil {
+		log.Printf("ERROR: %s: %v", data.User, err)
+		data.Error = WebT(r, "web-login-failed")
+		return false
+	}
+
+	// the password is not kept, the next step only needs the secret
+	data.Pending = hex.EncodeToString(sessionRandom(16))
+	loginPendingMutex.Lock()
+	now := time.Now()
+	for id, pending := range loginPendings {
+		if now.After(pending.Expires) {
+			delete(loginPendings, id)
+		}
+	}
+	loginPendings[data.Pending] = &loginPending{
+		User:    data.User,
+		Secret:  secret,
+		Next:    data.Next,
+		Expires: now.Add(LoginPendingTime),
+	}
+	loginPendingMutex.Unlock()
+
+	return false
+}
+
+// loginCheckTOTP is the second step, it takes a TOTP code or a recovery code
+func loginCheckTOTP(r *http.Request, id string, data *LoginPage) bool {
+	loginPendingMutex.Lock()
+	defer loginPendingMutex.Unlock()
+
+	pending := loginPendings[id]
+	if pending == nil || time.Now().After(pending.Expires) {
+		delete(loginPendings, id)
+		data.Error = WebT(r, "web-login-expired")
+		return false
+	}
+	data.User, data.Next = pending.User, pending.Next
+
+	ipKey := "ip:" + ServeClientIP(r)
+	userKey := "user:" + strings.ToLower(pending.User)
+	if max(loginThrottle.Locked(ipKey), loginThrottle.Locked(userKey)) > 0 {
+		delete(loginPendings, id)
+		data.Error = fmt.Sprintf(WebT(r, "web-login-locked"), int(LoginLockout.Minutes()))
+		return false
+	}
+
+	code := r.PostFormValue("code")
+	if TOTPVerify(pending.User, pending.Secret, code) {
+		delete(loginPendings, id)
+		return true
+	}
+	if totp := serveUserTOTP(pending.User); totp != nil && TOTPUseRecovery(totp, code) {
+		log.Printf("WARN: %s: recovery code used", pending.User)
+		delete(loginPendings, id)
+		return true
+	}
+
+	log.Printf("WARN: %s: wrong TOTP code from %s", pending.User, ServeClientIP(r))
+	loginThrottle.Fail(ipKey)
+	loginThrottle.Fail(userKey)
+	pending.Attempts++
+	if pending.Attempts >= LoginMaxFailures {
+		delete(loginPendings, id)
+		data.Error = WebT(r, "web-login-expired")
+		return false
+	}
+
+	data.Pending = id
+	data.Error = WebT(r, "web-login-code-failed")
+	return false
+}
+
+// LogoutHandler ends the session, only by POST so that links can't log anyone out
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if !CSRFCheck(r) {
+		http.Error(w, "Forbidden (csrf)", http.StatusForbidden)
+		return
+	}
+
+	if user := SessionUser(r); user != "" {
+		log.Printf("INFO: %s: logout", user)
+	}
+	SessionEnd(w, r)
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// loginNext accepts only local paths, anything else could send the user to another site
+func loginNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/status"
+	}
+	return next
+}
+
+// ServeClientIP trusts X-Real-IP only from the local nginx proxy
+func ServeClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		if real := r.Header.Get("X-Real-IP"); real != "" {
+			return real
+		}
+	}
+	return host
+}
+
+func checkPasswordHash(password, hash string) bool {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	return err == nil
+}
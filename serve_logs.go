@@ -0,0 +1,542 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+const (
+	LogsPageLines    = 500
+	LogsMaxLineBytes = 1024 * 1024
+	LogsTailPoll     = time.Second
+
+	// a search reads this many lines of a container, not its whole log
+	LogsSearchDefault = 10000
+)
+
+// LogsFile is one file below SystemLogsRoot
+type LogsFile struct {
+	Path    string // relative to SystemLogsRoot, e.g. "01-wordpress/access.log"
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// LogsGroup are the files of one project (or of gd-tools itself)
+type LogsGroup struct {
+	Name  string
+	Files []LogsFile
+}
+
+// LogsView is a page of lines from a file or from docker logs
+type LogsView struct {
+	Source    string // "file" or "docker"
+	Path      string // file path or container name
+	Query     string
+	Regex     bool
+	Page      int
+	Lines     []string
+	HasOlder  bool
+	Error     string
+	TailLines int
+}
+
+var errLogsPath = errors.New("invalid log path")
+
+// SizeText formats the size for the file list, e.g. "1.5 MB"
+func (lf LogsFile) SizeText() string {
+	size := float64(lf.Size)
+	for _, unit := range []string{"B", "KB", "MB", "GB"} {
+		if size < 1024 || unit == "GB" {
+			if unit == "B" {
+				return fmt.Sprintf("%d B", lf.Size)
+			}
+			return fmt.Sprintf("%.1f %s", size, unit)
+		}
+		size /= 1024
+	}
+	return ""
+}
+
+func LogsHandler(w http.ResponseWriter, r *http.Request) {
+	groups, err := logsGroups()
+	if err != nil {
+		log.Println("WARN: logsGroups:", err)
+	}
+
+	containers, dockerErr := StatusSnapshot(r.Context())
+	if dockerErr != nil {
+		log.Println("WARN: StatusSnapshot:", dockerErr)
+	}
+
+	data := struct {
+		ServeConfig
+		Groups     []LogsGroup
+		Containers []StatusContainer
+		Error      string
+	}{
+		ServeConfig: ServeCurrent().Config,
+		Groups:      groups,
+		Containers:  containers,
+	}
+	if err != nil {
+		data.Error = err.Error()
+	} else if dockerErr != nil {
+		data.Error = dockerErr.Error()
+	}
+
+	content, err := ServeParsePage(w, r, ServeCurrent().Page("logs.html"), data)
+	if err != nil {
+		return
+	}
+
+	page := ServePage{
+		Title:   T("web-logs-title"),
+		Content: content,
+	}
+
+	page.Render(w, r)
+}
+
+// LogsViewHandler shows one page of a log file, newest lines last, optionally filtered
+func LogsViewHandler(w http.ResponseWriter, r *http.Request) {
+	view := logsViewFromRequest(r)
+	view.Source = "file"
+	view.Path = r.FormValue("file")
+
+	path, err := logsResolve(view.Path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	match, err := logsMatcher(view.Query, view.Regex)
+	if err != nil {
+		view.Error = err.Error()
+	} else {
+		view.Lines, view.HasOlder, err = logsReadPage(path, view.Page, match)
+		if err != nil {
+			view.Error = err.Error()
+		}
+	}
+
+	logsRenderView(w, r, view)
+}
+
+// LogsDockerHandler shows the output of docker logs for one container
+func LogsDockerHandler(w http.ResponseWriter, r *http.Request) {
+	view := logsViewFromRequest(r)
+	view.Source = "docker"
+	view.Path = r.FormValue("container")
+
+	// only containers that exist, the name goes to the Docker API
+	containers, err := StatusSnapshot(r.Context())
+	if err != nil {
+		view.Error = err.Error()
+		logsRenderView(w, r, view)
+		return
+	}
+	found := false
+	for _, container := range containers {
+		if container.Name == view.Path {
+			found = true
+		}
+	}
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+
+	match, err := logsMatcher(view.Query, view.Regex)
+	if err != nil {
+		view.Error = err.Error()
+	} else {
+		view.Lines, err = logsDocker(r.Context(), view.Path, view.TailLines, match)
+		if err != nil {
+			view.Error = err.Error()
+		}
+	}
+
+	logsRenderView(w, r, view)
+}
+
+// LogsTailHandler streams new lines of a log file as server-sent events
+func LogsTailHandler(w http.ResponseWriter, r *http.Request) {
+	path, err := logsResolve(r.FormValue("file"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		http.Error(w, "Internal error (open)", 500)
+		return
+	}
+	defer file.Close()
+
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		http.Error(w, "Internal error (seek)", 500)
+		return
+	}
+
+	controller := http.NewResponseController(w)
+	controller.SetWriteDeadline(time.Time{}) // the stream has no end
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx must not buffer the stream
+	w.WriteHeader(http.StatusOK)
+	controller.Flush()
+
+	ticker := time.NewTicker(LogsTailPoll)
+	defer ticker.Stop()
+
+	var pending []byte
+	buf := make([]byte, 64*1024)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+
+		// start over if the file was truncated by logrotate (copytruncate)
+		if info, err := file.Stat(); err == nil && info.Size() < offset {
+			offset, pending = 0, nil
+			file.Seek(0, io.SeekStart)
+		}
+
+		sent := false
+		for {
+			n, err := file.Read(buf)
+			offset += int64(n)
+			pending = append(pending, buf[:n]...)
+			for {
+				index := bytes.IndexByte(pending, '\n')
+				if index < 0 {
+					break
+				}
+				line := strings.TrimRight(string(pending[:index]), "\r")
+				pending = pending[index+1:]
+				if _, err := fmt.Fprintf(w, "data: %s\n\n", line); err != nil {
+					return
+				}
+				sent = true
+			}
+			if err != nil || n == 0 {
+				break
+			}
+		}
+
+		if !sent {
+			// a comment keeps proxies from closing an idle stream
+			if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func logsViewFromRequest(r *http.Request) LogsView {
+	view := LogsView{
+		Query:     r.FormValue("q"),
+		Regex:     r.FormValue("regex") == "1",
+		TailLines: LogsPageLines,
+	}
+	if page, err := strconv.Atoi(r.FormValue("page")); err == nil && page > 0 {
+		view.Page = page
+	}
+	if tail, err := strconv.Atoi(r.FormValue("tail")); err == nil && tail > 0 && tail <= 10*LogsPageLines {
+		view.TailLines = tail
+	}
+
+	return view
+}
+
+func logsRenderView(w http.ResponseWriter, r *http.Request, view LogsView) {
+	content, err := ServeParsePage(w, r, ServeCurrent().Page("log-view.html"), view)
+	if err != nil {
+		return
+	}
+
+	page := ServePage{
+		Title:   T("web-logs-title"),
+		Content: content,
+	}
+
+	page.Render(w, r)
+}
+
+// OlderPage and NewerPage are the page numbers for the paging buttons
+func (lv LogsView) OlderPage() int {
+	return lv.Page + 1
+}
+
+func (lv LogsView) NewerPage() int {
+	return lv.Page - 1
+}
+
+// logsResolve turns a relative path into a regular file below SystemLogsRoot
+func logsResolve(rel string) (string, error) {
+	if rel == "" || filepath.IsAbs(rel) {
+		return "", errLogsPath
+	}
+
+	root, err := filepath.EvalSymlinks(SystemLogsRoot)
+	if err != nil {
+		return "", err
+	}
+
+	// Clean removes "..", EvalSymlinks catches links pointing outside
+	path, err := filepath.EvalSymlinks(filepath.Join(root, filepath.Clean("/"+rel)))
+	if err != nil {
+		return "", errLogsPath
+	}
+	if !strings.HasPrefix(path, root+string(filepath.Separator)) {
+		return "", errLogsPath
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", errLogsPath
+	}
+
+	return path, nil
+}
+
+// logsGroups lists the files per project directory, serve.log and friends go to "gd-tools"
+func logsGroups() ([]LogsGroup, error) {
+	entries, err := os.ReadDir(SystemLogsRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	own := LogsGroup{Name: "gd-tools"}
+	var groups []LogsGroup
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			if file, ok := logsFileInfo(entry.Name()); ok {
+				own.Files = append(own.Files, file)
+			}
+			continue
+		}
+
+		group := LogsGroup{Name: entry.Name()}
+		filepath.WalkDir(filepath.Join(SystemLogsRoot, entry.Name()), func(path string, d os.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			rel, _ := filepath.Rel(SystemLogsRoot, path)
+			if file, ok := logsFileInfo(rel); ok {
+				group.Files = append(group.Files, file)
+			}
+			return nil
+		})
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+
+	if len(own.Files) > 0 {
+		groups = append([]LogsGroup{own}, groups...)
+	}
+
+	return groups, nil
+}
+
+func logsFileInfo(rel string) (LogsFile, bool) {
+	info, err := os.Stat(filepath.Join(SystemLogsRoot, rel))
+	if err != nil || !info.Mode().IsRegular() {
+		return LogsFile{}, false
+	}
+
+	return LogsFile{
+		Path:    rel,
+		Name:    filepath.Base(rel),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}, true
+}
+
+// logsMatcher returns nil if there is nothing to search for
+func logsMatcher(query string, isRegex bool) (func(string) bool, error) {
+	if query == "" {
+		return nil, nil
+	}
+	if !isRegex {
+		return func(line string) bool {
+			return strings.Contains(line, query)
+		}, nil
+	}
+
+	re, err := regexp.Compile(query)
+	if err != nil {
+		return nil, err
+	}
+	return re.MatchString, nil
+}
+
+// logsReadPage returns page n counted from the end (0 is the newest) and whether older lines exist.
+// Without a filter only the needed blocks at the end of the file are read.
+func logsReadPage(path string, page int, match func(string) bool) ([]string, bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer file.Close()
+
+	if match != nil {
+		var lines []string
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), LogsMaxLineBytes)
+		for scanner.Scan() {
+			if match(scanner.Text()) {
+				lines = append(lines, scanner.Text())
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, false, err
+		}
+		return logsSlicePage(lines, page)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, false, err
+	}
+
+	// read backwards until there are enough lines for the requested page
+	needed := (page + 1) * LogsPageLines
+	offset := info.Size()
+	var data []byte
+	for offset > 0 && bytes.Count(data, []byte{'\n'}) <= needed {
+		size := int64(64 * 1024)
+		if offset < size {
+			size = offset
+		}
+		offset -= size
+		block := make([]byte, size)
+		if _, err := file.ReadAt(block, offset); err != nil && err != io.EOF {
+			return nil, false, err
+		}
+		data = append(block, data...)
+	}
+
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if offset > 0 && len(lines) > 0 {
+		lines = lines[1:] // the first line is incomplete
+	}
+	older := offset > 0
+
+	result, more, err := logsSlicePage(lines, page)
+	return result, more || older, err
+}
+
+func logsSlicePage(lines []string, page int) ([]string, bool, error) {
+	end := len(lines) - page*LogsPageLines
+	if end <= 0 {
+		return nil, false, nil
+	}
+	start := end - LogsPageLines
+	if start < 0 {
+		start = 0
+	}
+
+	return lines[start:end], start > 0, nil
+}
+
+// logsDocker returns the last lines of stdout and stderr of a container
+func logsDocker(ctx context.Context, container string, tail int, match func(string) bool) ([]string, error) {
+	cli, err := client.NewClientWithOpts(
+		client.WithHostFromEnv(),
+		client.WithAPIVersionNegotiation(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	options := types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Timestamps: true,
+		Tail:       strconv.Itoa(tail),
+	}
+	if match != nil {
+		options.Tail = strconv.Itoa(logsSearchLines())
+	}
+
+	reader, err := cli.ContainerLogs(ctx, container, options)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	// containers without a TTY multiplex stdout and stderr
+	tty := false
+	if info, err := cli.ContainerInspect(ctx, container); err == nil && info.Config != nil {
+		tty = info.Config.Tty
+	}
+	output, writer := io.Pipe()
+	defer output.Close()
+	go func() {
+		var err error
+		if tty {
+			_, err = io.Copy(writer, reader)
+		} else {
+			_, err = stdcopy.StdCopy(writer, writer, reader)
+		}
+		writer.CloseWithError(err)
+	}()
+
+	// filter line by line, only the last tail matches stay in memory
+	var lines []string
+	scanner := bufio.NewScanner(output)
+	scanner.Buffer(make([]byte, 64*1024), LogsMaxLineBytes)
+	for scanner.Scan() {
+		if match == nil || match(scanner.Text()) {
+			lines = append(lines, scanner.Text())
+			if len(lines) > tail {
+				lines = lines[1:]
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return lines, nil
+}
+
+func logsSearchLines() int {
+	if lines := ServeCurrent().Config.LogsSearchLines; lines > 0 {
+		return lines
+	}
+	return LogsSearchDefault
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+const (
+	MetricsPrefix       = "gd_tools_"
+	MetricsTimeout      = 10 * time.Second
+	MetricsDiskInterval = 5 * time.Minute         // walking the data dirs is expensive
+	MetricsCertRoot     = CertRemoteDir + "/live" // group gd-tools through CertHookPath
+)
+
+// metricsBuckets are the upper bounds of the request duration histogram in seconds
+var metricsBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type metricsRequestKey struct {
+	Method string
+	Route  string
+	Code   int
+}
+
+type metricsHistogram struct {
+	Counts []uint64 // one per bucket, not cumulative
+	Sum    float64
+	Count  uint64
+}
+
+// ServeMetrics collects the requests of serve itself and caches the disk usage
+type ServeMetrics struct {
+	mutex     sync.Mutex
+	requests  map[metricsRequestKey]uint64
+	durations map[string]*metricsHistogram
+
+	diskMutex   sync.Mutex
+	diskUsage   map[string]int64
+	diskUpdated time.Time
+	diskRunning bool
+}
+
+var serveMetrics = &ServeMetrics{
+	requests:  make(map[metricsRequestKey]uint64),
+	durations: make(map[string]*metricsHistogram),
+	diskUsage: make(map[string]int64),
+}
+
+// metricsRecorder remembers the status code, Flush and Hijack keep SSE and /ws working
+type metricsRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (mr *metricsRecorder) WriteHeader(code int) {
+	if mr.code == 0 {
+		mr.code = code
+	}
+	mr.ResponseWriter.WriteHeader(code)
+}
+
+func (mr *metricsRecorder) Write(b []byte) (int, error) {
+	if mr.code == 0 {
+		mr.code = http.StatusOK
+	}
+	return mr.ResponseWriter.Write(b)
+}
+
+func (mr *metricsRecorder) Flush() {
+	if flusher, ok := mr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// FlushError lets http.ResponseController report a closed connection
+func (mr *metricsRecorder) FlushError() error {
+	return http.NewResponseController(mr.ResponseWriter).Flush()
+}
+
+func (mr *metricsRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := mr.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("hijack not supported")
+	}
+	mr.code = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+func (mr *metricsRecorder) Unwrap() http.ResponseWriter {
+	return mr.ResponseWriter
+}
+
+// MetricsMiddleware counts requests per route pattern, so unknown URLs don't create new series
+func MetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		recorder := &metricsRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(recorder, r)
+
+		route := "other"
+		if _, pattern := serveMux.Handler(r); pattern != "" {
+			route = pattern
+		}
+		serveMetrics.Observe(r.Method, route, recorder.code, time.Since(started))
+	})
+}
+
+func (sm *ServeMetrics) Observe(method, route string, code int, duration time.Duration) {
+	if code == 0 {
+		code = http.StatusOK
+	}
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	sm.requests[metricsRequestKey{Method: method, Route: route, Code: code}]++
+
+	histogram := sm.durations[route]
+	if histogram == nil {
+		histogram = &metricsHistogram{Counts: make([]uint64, len(metricsBuckets))}
+		sm.durations[route] = histogram
+	}
+	seconds := duration.Seconds()
+	for index, bound := range metricsBuckets {
+		if seconds <= bound {
+			histogram.Counts[index]++
+			break
+		}
+	}
+	histogram.Sum += seconds
+	histogram.Count++
+}
+
+// MetricsHandler answers the Prometheus scraper in the text exposition format
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	if !metricsAllowed(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), MetricsTimeout)
+	defer cancel()
+
+	started := time.Now()
+	var mw metricsWriter
+
+	dockerUp := 1
+	if err := metricsContainers(ctx, &mw); err != nil {
+		log.Println("WARN: metricsContainers:", err)
+		dockerUp = 0
+	}
+	mw.Header("docker_up", "gauge", "Whether the Docker API answered.")
+	mw.Sample("docker_up", float64(dockerUp))
+
+	serveMetrics.writeDisk(&mw)
+	metricsCertificates(&mw)
+	serveMetrics.writeRequests(&mw)
+
+	mw.Header("scrape_duration_seconds", "gauge", "Time needed to collect these metrics.")
+	mw.Sample("scrape_duration_seconds", time.Since(started).Seconds())
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(mw.String()))
+}
+
+// metricsAllowed wants the bearer token if one is configured, otherwise a direct local connection
+func metricsAllowed(r *http.Request) bool {
+	if token := ServeCurrent().Config.MetricsToken; token != "" {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		return ok && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+	}
+
+	// requests through nginx come from 127.0.0.1 as well
+	if r.Header.Get("X-Forwarded-For") != "" || r.Header.Get("X-Real-IP") != "" {
+		return false
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+type metricsContainer struct {
+	StatusContainer
+	Restarts int
+	Stats    *types.StatsJSON
+}
+
+// metricsContainers writes state and restarts of all containers, CPU and memory of the running ones
+func metricsContainers(ctx context.Context, mw *metricsWriter) error {
+	cli, err := client.NewClientWithOpts(
+		client.WithHostFromEnv(),
+		client.WithAPIVersionNegotiation(),
+	)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	containers, err := StatusSnapshot(ctx)
+	if err != nil {
+		return err
+	}
+
+	// one stats call takes a moment, ask for all containers at once
+	results := make([]metricsContainer, len(containers))
+	var wg sync.WaitGroup
+	for index, container := range containers {
+		results[index].StatusContainer = container
+		wg.Add(1)
+		go func(result *metricsContainer) {
+			defer wg.Done()
+			if info, err := cli.ContainerInspect(ctx, result.ID); err == nil {
+				result.Restarts = info.RestartCount
+			}
+			if result.State == "running" {
+				result.Stats = metricsStats(ctx, cli, result.ID)
+			}
+		}(&results[index])
+	}
+	wg.Wait()
+
+	mw.Header("container_running", "gauge", "Whether the container is running.")
+	for _, result := range results {
+		running := 0.0
+		if result.State == "running" {
+			running = 1
+		}
+		mw.Sample("container_running", running, "name", result.Name, "project", result.Project, "state", result.State)
+	}
+
+	mw.Header("container_restarts_total", "counter", "Restarts of the container by Docker.")
+	for _, result := range results {
+		mw.Sample("container_restarts_total", float64(result.Restarts), "name", result.Name, "project", result.Project)
+	}
+
+	mw.Header("container_cpu_seconds_total", "counter", "CPU time used by the container.")
+	for _, result := range results {
+		if result.Stats != nil {
+			cpu := float64(result.Stats.CPUStats.CPUUsage.TotalUsage) / float64(time.Second)
+			mw.Sample("container_cpu_seconds_total", cpu, "name", result.Name, "project", result.Project)
+		}
+	}
+
+	mw.Header("container_memory_bytes", "gauge", "Memory used by the container without the page cache.")
+	for _, result := range results {
+		if result.Stats != nil {
+			mw.Sample("container_memory_bytes", float64(metricsMemory(result.Stats.MemoryStats)), "name", result.Name, "project", result.Project)
+		}
+	}
+
+	mw.Header("container_memory_limit_bytes", "gauge", "Memory limit of the container.")
+	for _, result := range results {
+		if result.Stats != nil {
+			mw.Sample("container_memory_limit_bytes", float64(result.Stats.MemoryStats.Limit), "name", result.Name, "project", result.Project)
+		}
+	}
+
+	return nil
+}
+
+func metricsStats(ctx context.Context, cli *client.Client, id string) *types.StatsJSON {
+	response, err := cli.ContainerStatsOneShot(ctx, id)
+	if err != nil {
+		return nil
+	}
+	defer response.Body.Close()
+
+	var stats types.StatsJSON
+	if err := json.NewDecoder(response.Body).Decode(&stats); err != nil {
+		return nil
+	}
+	return &stats
+}
+
+// metricsMemory subtracts the cache like "docker stats" does (cgroup v1 and v2)
+func metricsMemory(stats types.MemoryStats) uint64 {
+	cache, ok := stats.Stats["total_inactive_file"]
+	if !ok {
+		cache = stats.Stats["inactive_file"]
+	}
+	if cache > stats.Usage {
+		return 0
+	}
+	return stats.Usage - cache
+}
+
+// writeDisk returns the last known sizes and starts a new walk in the background when they are old
+func (sm *ServeMetrics) writeDisk(mw *metricsWriter) {
+	sm.diskMutex.Lock()
+	defer sm.diskMutex.Unlock()
+
+	if !sm.diskRunning && time.Since(sm.diskUpdated) > MetricsDiskInterval {
+		sm.diskRunning = true
+		go sm.updateDisk()
+	}
+
+	names := make([]string, 0, len(sm.diskUsage))
+	for name := range sm.diskUsage {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	mw.Header("data_dir_bytes", "gauge", "Size of the project's data directory.")
+	for _, name := range names {
+		mw.Sample("data_dir_bytes", float64(sm.diskUsage[name]), "project", name)
+	}
+}
+
+func (sm *ServeMetrics) updateDisk() {
+	usage := make(map[string]int64)
+
+	entries, err := os.ReadDir(SystemDataRoot)
+	if err != nil {
+		log.Println("WARN: updateDisk:", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		var size int64
+		// files of other users (e.g. a database) are skipped, the sum is a lower bound then
+		filepath.WalkDir(filepath.Join(SystemDataRoot, entry.Name()), func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.Type().IsRegular() {
+				if info, err := d.Info(); err == nil {
+					size += info.Size()
+				}
+			}
+			return nil
+		})
+		usage[entry.Name()] = size
+	}
+
+	sm.diskMutex.Lock()
+	defer sm.diskMutex.Unlock()
+
+	sm.diskUsage = usage
+	sm.diskUpdated = time.Now()
+	sm.diskRunning = false
+}
+
+// metricsCertificates writes the days until each Let's Encrypt certificate expires
+func metricsCertificates(mw *metricsWriter) {
+	failed := 0
+	entries, err := os.ReadDir(MetricsCertRoot)
+	if err != nil && !os.IsNotExist(err) {
+		log.Println("WARN: metricsCertificates:", err)
+		failed++
+	}
+
+	mw.Header("certificate_expiry_days", "gauge", "Days until the certificate expires.")
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		cert, err := certParseFile(filepath.Join(MetricsCertRoot, entry.Name(), "cert.pem"))
+		if err != nil {
+			failed++
+			continue
+		}
+		days := time.Until(cert.NotAfter).Hours() / 24
+		mw.Sample("certificate_expiry_days", days, "name", entry.Name())
+	}
+
+	mw.Header("certificate_errors", "gauge", "Certificates that could not be read.")
+	mw.Sample("certificate_errors", float64(failed))
+}
+
+func (sm *ServeMetrics) writeRequests(mw *metricsWriter) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	keys := make([]metricsRequestKey, 0, len(sm.requests))
+	for key := range sm.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Route != keys[j].Route {
+			return keys[i].Route < keys[j].Route
+		}
+		if keys[i].Method != keys[j].Method {
+			return keys[i].Method < keys[j].Method
+		}
+		return keys[i].Code < keys[j].Code
+	})
+
+	mw.Header("http_requests_total", "counter", "Requests answered by serve.")
+	for _, key := range keys {
+		mw.Sample("http_requests_total", float64(sm.requests[key]),
+			"method", key.Method, "route", key.Route, "code", fmt.Sprint(key.Code))
+	}
+
+	routes := make([]string, 0, len(sm.durations))
+	for route := range sm.durations {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+
+	mw.Header("http_request_duration_seconds", "histogram", "Time needed to answer a request.")
+	for _, route := range routes {
+		histogram := sm.durations[route]
+		var cumulative uint64
+		for index, bound := range metricsBuckets {
+			cumulative += histogram.Counts[index]
+			mw.Sample("http_request_duration_seconds_bucket", float64(cumulative),
+				"route", route, "le", fmt.Sprint(bound))
+		}
+		mw.Sample("http_request_duration_seconds_bucket", float64(histogram.Count), "route", route, "le", "+Inf")
+		mw.Sample("http_request_duration_seconds_sum", histogram.Sum, "route", route)
+		mw.Sample("http_request_duration_seconds_count", float64(histogram.Count), "route", route)
+	}
+}
+
+// metricsWriter builds the text exposition format, all names get MetricsPrefix
+type metricsWriter struct {
+	strings.Builder
+}
+
+func (mw *metricsWriter) Header(name, kind, help string) {
+	fmt.Fprintf(mw, "# HELP %s%s %s\n", MetricsPrefix, name, help)
+	fmt.Fprintf(mw, "# TYPE %s%s %s\n", MetricsPrefix, name, kind)
+}
+
+// Sample takes the labels as name, value pairs
+func (mw *metricsWriter) Sample(name string, value float64, labels ...string) {
+	mw.WriteString(MetricsPrefix + name)
+	if len(labels) > 0 {
+		pairs := make([]string, 0, len(labels)/2)
+		for index := 0; index+1 < len(labels); index += 2 {
+			pairs = append(pairs, fmt.Sprintf("%s=%q", labels[index], metricsEscape(labels[index+1])))
+		}
+		mw.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+	fmt.Fprintf(mw, " %g\n", value)
+}
+
+// metricsEscape leaves only what %q and Prometheus agree on
+func metricsEscape(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e {
+			return '_'
+		}
+		return r
+	}, value)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+const ProjectActionTimeout = 5 * time.Minute
+
+// ProjectView is one row of the /projects page
+type ProjectView struct {
+	Name       string            `json:"name"`
+	Kind       string            `json:"kind"`
+	Port       string            `json:"port"`
+	Enabled    bool              `json:"enabled"`
+	Running    int               `json:"running"`
+	Containers []StatusContainer `json:"containers"`
+}
+
+// ProjectAction is the confirmation and result page of start, stop and restart
+type ProjectAction struct {
+	Project   string
+	Action    string
+	CSRFToken string
+	Done      bool
+	Output    string
+	Error     string
+}
+
+// docker compose commands behind the buttons
+var projectActions = map[string][]string{
+	"start":   {"up", "-d"},
+	"stop":    {"stop"},
+	"restart": {"restart"},
+}
+
+func (pv ProjectView) Health() string {
+	switch {
+	case len(pv.Containers) == 0:
+		return "none"
+	case pv.Running == len(pv.Containers):
+		return "running"
+	case pv.Running == 0:
+		return "stopped"
+	}
+	return "partial"
+}
+
+func ProjectsHandler(w http.ResponseWriter, r *http.Request) {
+	views, dockerErr, err := ProjectViews(r.Context())
+	if err != nil {
+		log.Println("ERROR: ProjectLoadAll:", err)
+		http.Error(w, "Internal error (projects)", 500)
+		return
+	}
+	if dockerErr != nil {
+		log.Println("WARN: StatusSnapshot:", dockerErr)
+	}
+
+	data := struct {
+		ServeConfig
+		Projects    []ProjectView
+		DockerError string
+		CanOperate  bool
+	}{
+		ServeConfig: ServeCurrent().Config,
+		Projects:    views,
+		CanOperate:  RoleAllows(SessionRole(r), RoleOperator),
+	}
+	if dockerErr != nil {
+		data.DockerError = dockerErr.Error()
+	}
+
+	content, err := ServeParsePage(w, r, ServeCurrent().Page("projects.html"), data)
+	if err != nil {
+		return
+	}
+
+	page := ServePage{
+		Title:   T("web-projects-title"),
+		Content: content,
+	}
+
+	page.Render(w, r)
+}
+
+// ProjectViews joins the projects with their containers, a Docker error leaves them empty
+func ProjectViews(ctx context.Context) ([]ProjectView, error, error) {
+	projects, err := ProjectLoadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	SortProjectsAscending(projects)
+
+	containers, dockerErr := StatusSnapshot(ctx)
+
+	var views []ProjectView
+	for _, project := range projects {
+		view := ProjectView{
+			Name: project.GetName(),
+			Kind: project.Kind,
+		}
+		if number, err := strconv.Atoi(project.Prefix); err == nil {
+			view.Port = strconv.Itoa(number + 8000)
+		}
+		if err := project.LoadConfig(); err == nil {
+			view.Enabled = project.IsEnabled
+		}
+
+		// compose uses the lower case directory name as project label
+		for _, container := range containers {
+			if container.Project == strings.ToLower(view.Name) {
+				view.Containers = append(view.Containers, container)
+				if container.State == "running" {
+					view.Running++
+				}
+			}
+		}
+		views = append(views, view)
+	}
+
+	return views, dockerErr, nil
+}
+
+// ProjectActionHandler asks for confirmation on GET and runs the action on POST
+func ProjectActionHandler(w http.ResponseWriter, r *http.Request) {
+	action := ProjectAction{
+		Project: r.FormValue("project"),
+		Action:  r.FormValue("action"),
+	}
+
+	project, err := projectFind(action.Project)
+	if err != nil || project == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if _, ok := projectActions[action.Action]; !ok {
+		http.Error(w, "Bad request (action)", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		action.CSRFToken = CSRFToken(w, r)
+	case http.MethodPost:
+		if !CSRFCheck(r) {
+			http.Error(w, "Forbidden (csrf)", http.StatusForbidden)
+			return
+		}
+		// docker compose may take longer than the server's WriteTimeout
+		deadline := time.Now().Add(ProjectActionTimeout + time.Minute)
+		http.NewResponseController(w).SetWriteDeadline(deadline)
+
+		log.Printf("INFO: %s: %s %s", SessionUser(r), action.Action, action.Project)
+
+		action.Output, err = projectRun(project, action.Action)
+		if err != nil {
+			action.Error = err.Error()
+			log.Printf("WARN: %s %s: %v", action.Action, action.Project, err)
+		}
+		action.Done = true
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	content, err := ServeParsePage(w, r, ServeCurrent().Page("project-action.html"), action)
+	if err != nil {
+		return
+	}
+
+	page := ServePage{
+		Title:   T("web-projects-title"),
+		Content: content,
+	}
+
+	page.Render(w, r)
+}
+
+// projectFind accepts only names from ProjectLoadAll, never a path
+func projectFind(name string) (*Project, error) {
+	projects, err := ProjectLoadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	index := slices.IndexFunc(projects, func(p *Project) bool {
+		return p.GetName() == name
+	})
+	if index < 0 {
+		return nil, nil
+	}
+
+	return projects[index], nil
+}
+
+// projectRun calls docker compose in the project directory, as the user running serve
+func projectRun(project *Project, action string) (string, error) {
+	dir, err := project.GetPath()
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ProjectActionTimeout)
+	defer cancel()
+
+	args := append([]string{"compose"}, projectActions[action]...)
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+
+	return string(out), err
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// ServeSite is everything SIGHUP reloads, it is only ever replaced as a whole
+type ServeSite struct {
+	Config     ServeConfig
+	SessionKey []byte
+	Pages      map[string]string // file name in www/ and its content
+}
+
+// servePages are read at the start and on every SIGHUP
+var servePages = []string{
+	"application.html",
+	"home.html",
+	"status.html",
+	"projects.html",
+	"project-action.html",
+	"logs.html",
+	"log-view.html",
+	"login.html",
+	"users.html",
+	"openapi.json",
+}
+
+var serveSite atomic.Pointer[ServeSite]
+
+// ServeCurrent is the site of this moment, a request should ask only once
+func ServeCurrent() *ServeSite {
+	return serveSite.Load()
+}
+
+func (site *ServeSite) Page(name string) string {
+	return site.Pages[name]
+}
+
+// ServeLoadSite reads and checks config and pages, previous keeps a generated session key
+func ServeLoadSite(previous *ServeSite) (*ServeSite, error) {
+	path := filepath.Join("/etc", ServeConfigName)
+	config, err := serveLoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	site := &ServeSite{
+		Config: *config,
+		Pages:  make(map[string]string),
+	}
+	var previousKey []byte
+	if previous != nil {
+		previousKey = previous.SessionKey
+	}
+	site.SessionKey = SessionKeyFor(site.Config, previousKey)
+
+	for _, name := range servePages {
+		content, err := ServeLoadPage(name)
+		if err != nil {
+			return nil, err
+		}
+		if err := servePageCheck(name, content); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		site.Pages[name] = content
+	}
+
+	return site, nil
+}
+
+// servePageCheck finds syntax errors before a broken page replaces a working one
+func servePageCheck(name, content string) error {
+	if strings.HasSuffix(name, ".json") {
+		if !json.Valid([]byte(content)) {
+			return fmt.Errorf("invalid JSON")
+		}
+		return nil
+	}
+
+	_, err := template.New(name).Funcs(template.FuncMap{
+		"T": func(key string, args ...interface{}) string { return key },
+	}).Parse(content)
+	return err
+}
+
+// ServeReload is called on SIGHUP, on any error the running site stays
+func ServeReload() {
+	current := ServeCurrent()
+
+	site, err := ServeLoadSite(current)
+	if err != nil {
+		log.Println("ERROR: reload failed, keeping the old version:", err)
+		return
+	}
+	if site.Config.Address != current.Config.Address {
+		log.Printf("WARN: reload: address %s needs a restart, still listening on %s",
+			site.Config.Address, current.Config.Address)
+	}
+
+	serveSite.Store(site)
+	log.Println("INFO: reloaded", ServeConfigName, "and", len(site.Pages), "pages")
+}
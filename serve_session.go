@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	SessionCookieName   = "gd_session"
+	SessionDefaultHours = 12
+)
+
+// Session is stored in the cookie itself, signed with the session key
+type Session struct {
+	User    string `json:"u"`
+	Expires int64  `json:"e"` // unix seconds
+	Nonce   string `json:"n"` // identifies the session for logout and CSRF
+}
+
+type sessionContextKey struct{}
+
+// SessionKeyFor takes the key from the config, without one sessions end with the process
+func SessionKeyFor(config ServeConfig, previous []byte) []byte {
+	if key, err := hex.DecodeString(config.SessionKey); err == nil && len(key) >= 32 {
+		return key
+	}
+	// a reload must not log everybody out
+	if previous != nil {
+		return previous
+	}
+
+	log.Println("WARN: no session_key in config, sessions will not survive a restart")
+	return sessionRandom(32)
+}
+
+// SessionNewKey returns a fresh hex encoded key for ServeConfig.SessionKey
+func SessionNewKey() string {
+	return hex.EncodeToString(sessionRandom(32))
+}
+
+func sessionRandom(size int) []byte {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err) // no randomness, no security
+	}
+	return buf
+}
+
+// SessionStart logs the user in by setting the signed cookie
+func SessionStart(w http.ResponseWriter, r *http.Request, user string) {
+	hours := ServeCurrent().Config.SessionHours
+	if hours <= 0 {
+		hours = SessionDefaultHours
+	}
+	session := Session{
+		User:    user,
+		Expires: time.Now().Add(time.Duration(hours) * time.Hour).Unix(),
+		Nonce:   hex.EncodeToString(sessionRandom(16)),
+	}
+
+	payload, _ := json.Marshal(session)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	signature := base64.RawURLEncoding.EncodeToString(sessionSign(encoded, user))
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    encoded + "." + signature,
+		Path:     "/",
+		Expires:  time.Unix(session.Expires, 0),
+		HttpOnly: true,
+		Secure:   ServeIsHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// SessionEnd revokes the current session and removes the cookie
+func SessionEnd(w http.ResponseWriter, r *http.Request) {
+	if session := SessionGet(r); session != nil {
+		sessionRevoke(session)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   ServeIsHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// sessionRevoke keeps the nonce in the state file, a restart must not bring the session back
+func sessionRevoke(session *Session) {
+	serveStateMutex.Lock()
+	defer serveStateMutex.Unlock()
+
+	now := time.Now().Unix()
+	for nonce, expires := range serveState.Revoked {
+		if now > expires {
+			delete(serveState.Revoked, nonce)
+		}
+	}
+	if serveState.Revoked == nil {
+		serveState.Revoked = make(map[string]int64)
+	}
+	serveState.Revoked[session.Nonce] = session.Expires
+
+	if err := serveState.save(); err != nil {
+		log.Println("ERROR: ServeState, logout only until the restart:", err)
+	}
+}
+
+// SessionGet returns the valid session of the request or nil
+func SessionGet(r *http.Request) *Session {
+	if session, ok := r.Context().Value(sessionContextKey{}).(*Session); ok {
+		return session
+	}
+
+	cookie, err := r.Cookie(SessionCookieName)
+	if err != nil {
+		return nil
+	}
+	encoded, signature, ok := strings.Cut(cookie.Value, ".")
+	if !ok {
+		return nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil
+	}
+	var session Session
+	if err := json.Unmarshal(payload, &session); err != nil {
+		return nil
+	}
+
+	given, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(given, sessionSign(encoded, session.User)) {
+		return nil
+	}
+	if time.Now().Unix() > session.Expires {
+		return nil
+	}
+
+	serveStateMutex.Lock()
+	_, revoked := serveState.Revoked[session.Nonce]
+	serveStateMutex.Unlock()
+	if revoked {
+		return nil
+	}
+
+	return &session
+}
+
+// SessionUser is the logged in user for log messages, empty without a session
+func SessionUser(r *http.Request) string {
+	if session := SessionGet(r); session != nil {
+		return session.User
+	}
+	return ""
+}
+
+// sessionSign includes the password hash, so a new password ends all sessions of the user
+func sessionSign(encoded, user string) []byte {
+	mac := hmac.New(sha256.New, ServeCurrent().SessionKey)
+	mac.Write([]byte(encoded))
+	mac.Write([]byte{0})
+	mac.Write([]byte(serveUserHash(user)))
+	return mac.Sum(nil)
+}
+
+// CSRFToken of a session is derived from its nonce, there is no cookie to steal
+func (s *Session) CSRFToken() string {
+	mac := hmac.New(sha256.New, ServeCurrent().SessionKey)
+	mac.Write([]byte("csrf\x00" + s.Nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Session) CSRFCheck(form string) bool {
+	return subtle.ConstantTimeCompare([]byte(s.CSRFToken()), []byte(form)) == 1
+}
+
+// serveUserHash returns the bcrypt hash of a web user, empty if there is none
+func serveUserHash(name string) string {
+	if user := ServeCurrent().Config.FindUser(name); user != nil {
+		return user.Password
+	}
+	return ""
+}
+
+// serveUserTOTP returns the second factor of a web user, nil if there is none
+func serveUserTOTP(name string) *ServeTOTP {
+	if user := ServeCurrent().Config.FindUser(name); user != nil {
+		return user.TOTP
+	}
+	return nil
+}
+
+// AuthMiddleware sends browsers without a session to the login page
+func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := SessionGet(r)
+		if session == nil {
+			// only plain page views can come back after the login
+			if r.Method == http.MethodGet && r.Header.Get("Upgrade") == "" && r.URL.Path != "/logs/tail" {
+				target := "/login?next=" + url.QueryEscape(r.URL.RequestURI())
+				http.Redirect(w, r, target, http.StatusSeeOther)
+				return
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), sessionContextKey{}, session)
+		next(w, r.WithContext(ctx))
+	}
+}
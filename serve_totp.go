@@ -0,0 +1,254 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	TOTPIssuer        = "gd-tools"
+	TOTPPeriod        = 30 // seconds, RFC 6238 default
+	TOTPDigits        = 6
+	TOTPSkew          = 1 // accept one period before and after (clock drift)
+	TOTPSecretBytes   = 20
+	TOTPRecoveryCount = 10
+)
+
+// ServeStateDir belongs to gd-tools, the config in /etc is read-only for serve
+const ServeStateDir = SystemVarMount + "/serve"
+
+// ServeStateName is written by serve itself
+var ServeStateName = filepath.Join(ServeStateDir, "serve-state.json")
+
+// ServeTOTP is the second factor of a web user.
+// Secret is encrypted with a key derived from the password, so the config alone reveals nothing.
+type ServeTOTP struct {
+	Secret   string   `json:"secret"`   // base64(salt | nonce | AES-GCM ciphertext)
+	Recovery []string `json:"recovery"` // bcrypt hashes of the one-time recovery codes
+}
+
+// ServeState remembers what must survive a restart of serve
+type ServeState struct {
+	UsedRecovery []string         `json:"used_recovery"`              // hashes from ServeTOTP.Recovery
+	Revoked      map[string]int64 `json:"revoked_sessions,omitempty"` // nonce of logged out sessions, until they expire
+}
+
+var (
+	totpMutex    sync.Mutex
+	totpLastStep = make(map[string]int64) // per user, a code is accepted only once
+
+	serveStateMutex sync.Mutex
+	serveState      ServeState
+)
+
+var errTOTPDecrypt = errors.New("totp: cannot decrypt secret")
+
+// TOTPNewSecret returns a random secret in base32 as authenticator apps expect it
+func TOTPNewSecret() string {
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(sessionRandom(TOTPSecretBytes))
+}
+
+// TOTPURI is the otpauth URI for QR codes and manual entry
+func TOTPURI(user, secret string) string {
+	label := url.PathEscape(TOTPIssuer + ":" + user)
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", TOTPIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(TOTPDigits))
+	query.Set("period", fmt.Sprint(TOTPPeriod))
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// TOTPCode computes the code of one time step (RFC 4226 with the RFC 6238 counter)
+func TOTPCode(secret string, step int64) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	modulo := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		modulo *= 10
+	}
+
+	return fmt.Sprintf("%0*d", TOTPDigits, value%modulo), nil
+}
+
+// TOTPVerify checks the code against the current time and refuses a replay
+func TOTPVerify(user, secret, code string) bool {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != TOTPDigits {
+		return false
+	}
+
+	current := time.Now().Unix() / TOTPPeriod
+	for step := current - TOTPSkew; step <= current+TOTPSkew; step++ {
+		expected, err := TOTPCode(secret, step)
+		if err != nil || !hmac.Equal([]byte(expected), []byte(code)) {
+			continue
+		}
+
+		totpMutex.Lock()
+		defer totpMutex.Unlock()
+		if step <= totpLastStep[user] {
+			return false
+		}
+		totpLastStep[user] = step
+		return true
+	}
+
+	return false
+}
+
+// TOTPEncrypt protects the secret with the user's password
+func TOTPEncrypt(secret, password string) (string, error) {
+	salt := sessionRandom(16)
+	gcm, err := totpCipher(password, salt)
+	if err != nil {
+		return "", err
+	}
+	nonce := sessionRandom(gcm.NonceSize())
+
+	sealed := gcm.Seal(nil, nonce, []byte(secret), nil)
+	blob := append(append(salt, nonce...), sealed...)
+	return base64.StdEncoding.EncodeToString(blob), nil
+}
+
+func TOTPDecrypt(encrypted, password string) (string, error) {
+	blob, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil || len(blob) < 16 {
+		return "", errTOTPDecrypt
+	}
+	salt, rest := blob[:16], blob[16:]
+
+	gcm, err := totpCipher(password, salt)
+	if err != nil {
+		return "", err
+	}
+	if len(rest) < gcm.NonceSize() {
+		return "", errTOTPDecrypt
+	}
+
+	secret, err := gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errTOTPDecrypt
+	}
+	return string(secret), nil
+}
+
+func totpCipher(password string, salt []byte) (cipher.AEAD, error) {
+	key := argon2.IDKey([]byte(password), salt, 1, 64*1024, 4, 32)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// TOTPNewRecovery returns the codes to show once and their hashes for the config
+func TOTPNewRecovery() ([]string, []string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	var codes, hashes []string
+	for i := 0; i < TOTPRecoveryCount; i++ {
+		raw := strings.ToLower(encoding.EncodeToString(sessionRandom(5)))
+		code := raw[:4] + "-" + raw[4:]
+		hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, nil, err
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, string(hash))
+	}
+
+	return codes, hashes, nil
+}
+
+// TOTPUseRecovery accepts each recovery code only once, also across restarts
+func TOTPUseRecovery(totp *ServeTOTP, code string) bool {
+	code = strings.ToLower(strings.TrimSpace(code))
+
+	serveStateMutex.Lock()
+	defer serveStateMutex.Unlock()
+
+	for _, hash := range totp.Recovery {
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(code)) != nil {
+			continue
+		}
+		for _, used := range serveState.UsedRecovery {
+			if used == hash {
+				return false
+			}
+		}
+
+		// burnt in memory either way, but only a saved code may log in
+		serveState.UsedRecovery = append(serveState.UsedRecovery, hash)
+		if err := serveState.save(); err != nil {
+			log.Println("ERROR: ServeState, recovery code refused:", err)
+			return false
+		}
+		return true
+	}
+
+	return false
+}
+
+// ServeStateLoad reads the state file, a missing one is an empty state
+func ServeStateLoad() error {
+	if err := syscall.Access(ServeStateDir, 2 /* W_OK */); err != nil {
+		log.Printf("WARN: %s is not writable (%v), recovery codes cannot be used, see gd-tools system --only user", ServeStateDir, err)
+	}
+
+	content, err := os.ReadFile(ServeStateName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	serveStateMutex.Lock()
+	defer serveStateMutex.Unlock()
+	return json.Unmarshal(content, &serveState)
+}
+
+func (ss *ServeState) save() error {
+	content, err := json.MarshalIndent(ss, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	temp := ServeStateName + ".tmp"
+	if err := os.WriteFile(temp, content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(temp, ServeStateName)
+}
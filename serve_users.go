@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	RoleViewer   = "viewer"   // status and logs
+	RoleOperator = "operator" // also start, stop and restart
+	RoleAdmin    = "admin"    // also users and configuration
+)
+
+// serveRoles orders the roles, each one includes the rights of those before it
+var serveRoles = []string{RoleViewer, RoleOperator, RoleAdmin}
+
+// ServeUser is one login of the web UI
+type ServeUser struct {
+	Name     string     `json:"name"`
+	Password string     `json:"password"` // bcrypt, see "serve passwd"
+	Role     string     `json:"role"`
+	TOTP     *ServeTOTP `json:"totp,omitempty"` // see "serve totp enable"
+}
+
+// RoleRank returns the position in serveRoles, -1 for an unknown role
+func RoleRank(role string) int {
+	for index, known := range serveRoles {
+		if known == role {
+			return index
+		}
+	}
+	return -1
+}
+
+// RoleAllows tells whether role includes the rights of wanted
+func RoleAllows(role, wanted string) bool {
+	rank := RoleRank(role)
+	return rank >= 0 && rank >= RoleRank(wanted)
+}
+
+// FindUser compares the names case-insensitively, they are mostly mail addresses
+func (sc *ServeConfig) FindUser(name string) *ServeUser {
+	if name == "" {
+		return nil
+	}
+	for index := range sc.Users {
+		if strings.EqualFold(sc.Users[index].Name, name) {
+			return &sc.Users[index]
+		}
+	}
+	return nil
+}
+
+// SessionRole is the current role of the logged in user, a changed config applies at once
+func SessionRole(r *http.Request) string {
+	session := SessionGet(r)
+	if session == nil {
+		return ""
+	}
+	if user := ServeCurrent().Config.FindUser(session.User); user != nil {
+		return user.Role
+	}
+	return ""
+}
+
+// RoleMiddleware lets only users with at least the given role through
+func RoleMiddleware(role string, next http.HandlerFunc) http.HandlerFunc {
+	return AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if !RoleAllows(SessionRole(r), role) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// UserView is one row of the /users page, without hashes
+type UserView struct {
+	Name string
+	Role string
+	TOTP bool
+}
+
+func UsersHandler(w http.ResponseWriter, r *http.Request) {
+	site := ServeCurrent()
+
+	var users []UserView
+	for _, user := range site.Config.Users {
+		users = append(users, UserView{
+			Name: user.Name,
+			Role: user.Role,
+			TOTP: user.TOTP != nil,
+		})
+	}
+
+	data := struct {
+		ServeConfig
+		Users []UserView
+	}{
+		ServeConfig: site.Config,
+		Users:       users,
+	}
+
+	content, err := ServeParsePage(w, r, site.Page("users.html"), data)
+	if err != nil {
+		return
+	}
+
+	page := ServePage{
+		Title:   T("web-users-title"),
+		Content: content,
+	}
+
+	page.Render(w, r)
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	StatusProjectLabel = "com.docker.compose.project"
+	StatusPingInterval = 30 * time.Second // below the nginx proxy_read_timeout
+	StatusRetryDelay   = 10 * time.Second
+)
+
+// StatusContainer is the state of one container as shown on the status page
+type StatusContainer struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Project string `json:"project"`
+	State   string `json:"state"`  // e.g. "running", "exited", "removed"
+	Status  string `json:"status"` // e.g. "Up 3 hours (healthy)"
+	Health  string `json:"health,omitempty"`
+}
+
+// StatusMessage is sent as JSON over /ws
+type StatusMessage struct {
+	Type       string            `json:"type"` // "snapshot", "event", "ping" or "error"
+	Time       time.Time         `json:"time"`
+	Action     string            `json:"action,omitempty"`
+	Containers []StatusContainer `json:"containers,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// StatusHub distributes Docker events to all connected browsers
+type StatusHub struct {
+	mutex   sync.Mutex
+	clients map[chan StatusMessage]bool
+}
+
+var statusHub = &StatusHub{clients: make(map[chan StatusMessage]bool)}
+
+func (hub *StatusHub) Subscribe() chan StatusMessage {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	ch := make(chan StatusMessage, 16)
+	hub.clients[ch] = true
+	return ch
+}
+
+func (hub *StatusHub) Unsubscribe(ch chan StatusMessage) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	if hub.clients[ch] {
+		delete(hub.clients, ch)
+		close(ch)
+	}
+}
+
+// Broadcast never blocks, a client that cannot keep up is dropped
+func (hub *StatusHub) Broadcast(msg StatusMessage) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	for ch := range hub.clients {
+		select {
+		case ch <- msg:
+		default:
+			delete(hub.clients, ch)
+			close(ch)
+		}
+	}
+}
+
+// Run follows the Docker events stream until ctx is done and reconnects after errors
+func (hub *StatusHub) Run(ctx context.Context) {
+	for {
+		err := hub.follow(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("WARN: StatusHub: %v", err)
+		hub.Broadcast(StatusMessage{Type: "error", Time: time.Now(), Error: err.Error()})
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(StatusRetryDelay):
+		}
+	}
+}
+
+func (hub *StatusHub) follow(ctx context.Context) error {
+	cli, err := client.NewClientWithOpts(
+		client.WithHostFromEnv(),
+		client.WithAPIVersionNegotiation(),
+	)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	args := filters.NewArgs(filters.Arg("type", string(events.ContainerEventType)))
+	for _, action := range []string{"create", "start", "restart", "stop", "die", "pause", "unpause", "destroy", "health_status"} {
+		args.Add("event", action)
+	}
+	messages, errs := cli.Events(ctx, types.EventsOptions{Filters: args})
+
+	// the browsers may have missed events while we were disconnected
+	if containers, err := StatusSnapshot(ctx); err == nil {
+		hub.Broadcast(StatusMessage{Type: "snapshot", Time: time.Now(), Containers: containers})
+	}
+
+	for {
+		select {
+		case err := <-errs:
+			return err
+		case event := <-messages:
+			hub.Broadcast(statusFromEvent(ctx, cli, event))
+		}
+	}
+}
+
+func statusFromEvent(ctx context.Context, cli *client.Client, event events.Message) StatusMessage {
+	attrs := event.Actor.Attributes
+	container := StatusContainer{
+		ID:      event.Actor.ID,
+		Name:    attrs["name"],
+		Project: attrs[StatusProjectLabel],
+		State:   "removed",
+	}
+
+	// the event only tells what happened, ask for the resulting state
+	if event.Action != "destroy" {
+		inspectCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		// the list has the same status text as the snapshot, e.g. "Up 3 hours"
+		list, err := cli.ContainerList(inspectCtx, types.ContainerListOptions{
+			All:     true,
+			Filters: filters.NewArgs(filters.Arg("id", event.Actor.ID)),
+		})
+		if err == nil && len(list) == 1 {
+			container.State = list[0].State
+			container.Status = list[0].Status
+		}
+		if info, err := cli.ContainerInspect(inspectCtx, event.Actor.ID); err == nil && info.State != nil {
+			if container.Status == "" {
+				container.State = info.State.Status
+			}
+			if info.State.Health != nil {
+				container.Health = info.State.Health.Status
+			}
+		}
+	}
+
+	return StatusMessage{
+		Type:       "event",
+		Time:       time.Unix(0, event.TimeNano),
+		Action:     strings.TrimPrefix(event.Action, "health_status: "),
+		Containers: []StatusContainer{container},
+	}
+}
+
+// StatusSnapshot lists all containers, sorted by project and name
+func StatusSnapshot(ctx context.Context) ([]StatusContainer, error) {
+	cli, err := client.NewClientWithOpts(
+		client.WithHostFromEnv(),
+		client.WithAPIVersionNegotiation(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+
+	list, err := cli.ContainerList(ctx, types.ContainerListOptions{All: true})
+	if err != nil {
+		return nil, err
+	}
+
+	var containers []StatusContainer
+	for _, entry := range list {
+		name := entry.ID[:12]
+		if len(entry.Names) > 0 {
+			name = strings.TrimPrefix(entry.Names[0], "/")
+		}
+		containers = append(containers, StatusContainer{
+			ID:      entry.ID,
+			Name:    name,
+			Project: entry.Labels[StatusProjectLabel],
+			State:   entry.State,
+			Status:  entry.Status,
+		})
+	}
+	sort.Slice(containers, func(i, j int) bool {
+		if containers[i].Project != containers[j].Project {
+			return containers[i].Project < containers[j].Project
+		}
+		return containers[i].Name < containers[j].Name
+	})
+
+	return containers, nil
+}
+
+// WebSocketHandler sends a snapshot and then every change until the browser leaves
+var WebSocketHandler = websocket.Server{
+	Handshake: statusCheckOrigin,
+	Handler:   statusStream,
+}
+
+// statusCheckOrigin refuses pages from other sites, they would use our credentials
+func statusCheckOrigin(config *websocket.Config, r *http.Request) error {
+	origin, err := url.Parse(r.Header.Get("Origin"))
+	if err != nil || origin.Host != r.Host {
+		return websocket.ErrBadWebSocketOrigin
+	}
+	config.Origin = origin
+
+	return nil
+}
+
+func statusStream(ws *websocket.Conn) {
+	defer ws.Close()
+
+	// the server's WriteTimeout still applies to the hijacked connection
+	ws.SetDeadline(time.Time{})
+
+	updates := statusHub.Subscribe()
+	defer statusHub.Unsubscribe(updates)
+
+	snapshot := StatusMessage{Type: "snapshot", Time: time.Now()}
+	containers, err := StatusSnapshot(ws.Request().Context())
+	if err != nil {
+		snapshot = StatusMessage{Type: "error", Time: time.Now(), Error: err.Error()}
+	}
+	snapshot.Containers = containers
+	if err := websocket.JSON.Send(ws, snapshot); err != nil {
+		return
+	}
+
+	// reading notices a closed connection, the browser sends nothing else
+	closed := make(chan struct{})
+	go func() {
+		var ignored string
+		for websocket.Message.Receive(ws, &ignored) == nil {
+		}
+		close(closed)
+	}()
+
+	ticker := time.NewTicker(StatusPingInterval)
+	defer ticker.Stop()
+
+	for {
+		var msg StatusMessage
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+			msg = StatusMessage{Type: "ping", Time: time.Now()}
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			msg = update
+		}
+
+		if err := websocket.JSON.Send(ws, msg); err != nil {
+			return
+		}
+	}
+}
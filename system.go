@@ -16,9 +16,13 @@ const (
 )
 
 type Mount struct {
-	Provider   string `json:"provider"`   // e.g. "hetzner"
-	Identifier string `json:"identifier"` // e.g. "123456789"
-	Mountpoint string `json:"mountpoint"` // e.g. "/var/gd-tools"
+	Provider    string `json:"provider"`              // e.g. "hetzner"
+	Identifier  string `json:"identifier"`            // e.g. "123456789"
+	Mountpoint  string `json:"mountpoint"`            // e.g. "/var/gd-tools"
+	FSType      string `json:"fs_type,omitempty"`     // e.g. "ext4" (provider default)
+	Options     string `json:"options,omitempty"`     // fstab options (provider default)
+	Credentials string `json:"credentials,omitempty"` // CIFS credentials file
+	KeyFile     string `json:"key_file,omitempty"`    // LUKS key file
 }
 
 type SystemIDs struct {
@@ -28,6 +32,7 @@ type SystemIDs struct {
 }
 
 type SystemConfig struct {
+	Schema     int      `json:"schema"`      // layout of this file (see ConfigSchema)
 	Version    string   `json:"version"`     // e.g. v1.0.0
 	TimeZone   string   `json:"time_zone"`   // e.g. Europe/Berlin
 	HostName   string   `json:"host_name"`   // hostname (default FQDN)
@@ -37,6 +42,17 @@ type SystemConfig struct {
 	Packages   []string `json:"packages"`    // Required DEB packages
 	Mounts     []Mount  `json:"mounts"`      // Mounted filesystem (can grow)
 
+	NTP      *TimeConfig    `json:"ntp,omitempty"`       // timesyncd or chrony (skipped if missing)
+	Locale   *LocaleConfig  `json:"locale,omitempty"`    // generated and default locales (skipped if missing)
+	Swap     *SwapConfig    `json:"swap,omitempty"`      // swappiness and zram (optional)
+	Kernel   *KernelConfig  `json:"kernel,omitempty"`    // sysctl and limits.d (skipped if missing)
+	Firewall FirewallConfig `json:"firewall"`            // ufw rules managed by gd-tools
+	Docker   *DockerConfig  `json:"docker,omitempty"`    // managed keys of daemon.json (skipped if missing)
+	SSH      *SSHConfig     `json:"ssh,omitempty"`       // sshd hardening (skipped if missing)
+	Admins   []AdminKey     `json:"admins"`              // team members and their SSH keys
+	KeysKeep []string       `json:"keys_keep,omitempty"` // keys outside "admins" kept on the first run
+	Updates  *UpdatesConfig `json:"updates,omitempty"`   // unattended-upgrades (skipped if missing)
+
 	// container uid/gid - fetch after deployment
 	SystemIDs SystemIDs
 
@@ -68,8 +84,8 @@ func ReadSystemConfig(needIDs bool) (*SystemConfig, error) {
 	}
 
 	var systemConfig SystemConfig
-	if err := json.Unmarshal(content, &systemConfig); err != nil {
-		return nil, err
+	if _, err := ConfigDecode(content, systemMigrations, &systemConfig); err != nil {
+		return nil, fmt.Errorf("%s: %w", systemConfigPath, err)
 	}
 
 	if CheckEnv("dev") {
@@ -94,6 +110,7 @@ func ReadSystemConfig(needIDs bool) (*SystemConfig, error) {
 }
 
 func (sc SystemConfig) Save() error {
+	sc.Schema = ConfigSchema
 	content, err := json.MarshalIndent(sc, "", "  ")
 	if err != nil {
 		return err
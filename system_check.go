@@ -0,0 +1,305 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	CheckOK    = "OK"
+	CheckDrift = "DRIFT"
+	CheckError = "ERROR"
+)
+
+// SystemCheck is the result of comparing one item against gd-tools-system.json
+type SystemCheck struct {
+	Step     string
+	Item     string
+	Status   string
+	Expected string
+	Actual   string
+}
+
+// RunSystemCheck reports every step as OK, DRIFT or ERROR and never changes the host
+func (sc *SystemConfig) RunSystemCheck(steps []SystemStep) error {
+	var results []SystemCheck
+	for _, step := range steps {
+		for _, result := range step.Check(sc) {
+			result.Step = step.Method
+			results = append(results, result)
+		}
+	}
+
+	return SystemCheckReport(results)
+}
+
+// SystemCheckReport prints the results and exits with 1 on drift and 2 on errors
+func SystemCheckReport(results []SystemCheck) error {
+	drift, failed := 0, 0
+
+	fmt.Printf("%-18s %-20s %-6s %-30s %-30s\n", "STEP", "ITEM", "STATUS", "EXPECTED", "ACTUAL")
+	fmt.Println(strings.Repeat("-", 108))
+	for _, r := range results {
+		switch r.Status {
+		case CheckDrift:
+			drift++
+		case CheckError:
+			failed++
+		}
+		fmt.Printf("%-18s %-20s %-6s %-30s %-30s\n", r.Step, r.Item, r.Status, r.Expected, r.Actual)
+	}
+
+	if failed > 0 {
+		return cli.Exit(Tf("system-check-failed", failed, drift), 2)
+	}
+	if drift > 0 {
+		return cli.Exit(Tf("system-check-drift", drift), 1)
+	}
+
+	fmt.Println(T("system-check-okay"))
+	return nil
+}
+
+func checkCompare(item, expected, actual string) SystemCheck {
+	status := CheckOK
+	if expected != actual {
+		status = CheckDrift
+	}
+
+	return SystemCheck{Item: item, Status: status, Expected: expected, Actual: actual}
+}
+
+func checkFailed(item, expected string, err error) SystemCheck {
+	return SystemCheck{Item: item, Status: CheckError, Expected: expected, Actual: err.Error()}
+}
+
+func checkPresent(ok bool) string {
+	if ok {
+		return T("system-check-present")
+	}
+	return T("system-check-missing")
+}
+
+// checkContent shortens file contents to a comparable fingerprint
+func checkContent(content []byte) string {
+	if len(content) == 0 {
+		return T("system-check-missing")
+	}
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:6])
+}
+
+// checkDirAccess returns group and mode of a directory, like "gd-tools 0750"
+func checkDirAccess(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return checkPresent(false)
+	}
+	group := "?"
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		group = strconv.Itoa(int(stat.Gid))
+		if found, err := user.LookupGroupId(group); err == nil {
+			group = found.Name
+		}
+	}
+	return fmt.Sprintf("%s %04o", group, info.Mode().Perm())
+}
+
+func checkFileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func (sc *SystemConfig) CheckTimeZone() []SystemCheck {
+	currZone, err := FileGetLine("/etc/timezone")
+	if err != nil {
+		return []SystemCheck{checkFailed("/etc/timezone", sc.TimeZone, err)}
+	}
+
+	return []SystemCheck{checkCompare("/etc/timezone", sc.TimeZone, currZone)}
+}
+
+func (sc *SystemConfig) CheckHostName() []SystemCheck {
+	currName, err := FileGetLine("/etc/hostname")
+	if err != nil {
+		return []SystemCheck{checkFailed("/etc/hostname", sc.HostName, err)}
+	}
+
+	return []SystemCheck{checkCompare("/etc/hostname", sc.HostName, currName)}
+}
+
+func (sc *SystemConfig) CheckDockerRepo() []SystemCheck {
+	results := []SystemCheck{
+		checkCompare(filepath.Base(SystemDockerKey), checkPresent(true), checkPresent(systemDockerKeyValid(SystemDockerKey))),
+	}
+
+	repo, err := systemDockerRepo()
+	if err != nil {
+		return append(results, checkFailed(filepath.Base(SystemDockerList), "", err))
+	}
+	expected := systemDockerSource(repo, SystemDockerKey, sc.Docker.GetChannel())
+	expected = strings.TrimSpace(expected)
+
+	actual, err := FileGetLine(SystemDockerList)
+	if err != nil {
+		actual = checkPresent(false)
+	}
+
+	return append(results, checkCompare(filepath.Base(SystemDockerList), expected, actual))
+}
+
+func (sc *SystemConfig) CheckPackages() []SystemCheck {
+	var missing []string
+	for _, pkgName := range sc.Packages {
+		if err := exec.Command("dpkg", "-s", pkgName).Run(); err != nil {
+			missing = append(missing, pkgName)
+		}
+	}
+
+	actual := T("system-check-installed")
+	if len(missing) > 0 {
+		actual = strings.Join(missing, ",")
+	}
+	results := []SystemCheck{checkCompare("packages", T("system-check-installed"), actual)}
+
+	for _, service := range []string{"ssh", "docker", "nginx"} {
+		state, _ := ShellOutput("systemctl is-active " + service)
+		results = append(results, checkCompare(service, "active", state))
+	}
+
+	return results
+}
+
+func (sc *SystemConfig) CheckMounts() []SystemCheck {
+	mounted, err := checkMountedTargets()
+	if err != nil {
+		return []SystemCheck{checkFailed("/proc/mounts", "", err)}
+	}
+
+	var results []SystemCheck
+	for _, mount := range sc.Mounts {
+		actual := T("system-check-unmounted")
+		if _, ok := mounted[mount.Mountpoint]; ok {
+			actual = T("system-check-mounted")
+		}
+		results = append(results, checkCompare(mount.Mountpoint, T("system-check-mounted"), actual))
+	}
+
+	return results
+}
+
+func (sc *SystemConfig) CheckToolsUser() []SystemCheck {
+	var results []SystemCheck
+
+	env, err := FileGetLine(SystemEnvFile)
+	if err != nil {
+		env = checkPresent(false)
+	}
+	results = append(results, checkCompare(SystemEnvFile, "prod", env))
+
+	gdUser, err := user.Lookup("gd-tools")
+	if err != nil {
+		return append(results, checkCompare("gd-tools", checkPresent(true), checkPresent(false)))
+	}
+	results = append(results, checkCompare("gd-tools", checkPresent(true), checkPresent(true)))
+
+	groups := "-"
+	if dockerGroup, err := user.LookupGroup("docker"); err == nil {
+		if gids, err := gdUser.GroupIds(); err == nil && slices.Contains(gids, dockerGroup.Gid) {
+			groups = "docker"
+		}
+	}
+	results = append(results, checkCompare("groups", "docker", groups))
+
+	authKeys := filepath.Join(gdUser.HomeDir, ".ssh", "authorized_keys")
+	results = append(results, checkCompare("authorized_keys", checkPresent(true), checkPresent(checkFileExists(authKeys))))
+
+	for _, dir := range []string{SystemDataRoot, SystemLogsRoot, ServeStateDir} {
+		results = append(results, checkCompare(dir, checkPresent(true), checkPresent(checkFileExists(dir))))
+	}
+
+	return results
+}
+
+func (sc *SystemConfig) CheckCollectData() []SystemCheck {
+	results := []SystemCheck{
+		checkCompare("/etc/letsencrypt", checkPresent(true), checkPresent(checkFileExists("/etc/letsencrypt"))),
+	}
+
+	// serve reads the certificates as gd-tools, see the hook
+	expectedHook, err := TemplateLoad("certbot-gd-tools-hook.sh")
+	if err != nil {
+		return append(results, checkFailed(CertHookPath, "", err))
+	}
+	actualHook, _ := os.ReadFile(CertHookPath)
+	results = append(results, checkCompare(CertHookPath, checkContent(expectedHook), checkContent(actualHook)))
+	results = append(results, checkCompare(MetricsCertRoot, "gd-tools 0750", checkDirAccess(MetricsCertRoot)))
+
+	gdtUser, err := user.Lookup("gd-tools")
+	if err != nil {
+		return append(results, checkFailed(SystemIDsName, "", err))
+	}
+	dckGroup, err := user.LookupGroup("docker")
+	if err != nil {
+		return append(results, checkFailed(SystemIDsName, "", err))
+	}
+	expected := fmt.Sprintf("%s:%s:%s", gdtUser.Uid, gdtUser.Gid, dckGroup.Gid)
+
+	actual := checkPresent(false)
+	content, err := os.ReadFile(filepath.Join("/etc/letsencrypt", SystemIDsName))
+	if err == nil {
+		var ids SystemIDs
+		if err := json.Unmarshal(content, &ids); err != nil {
+			return append(results, checkFailed(SystemIDsName, expected, err))
+		}
+		actual = fmt.Sprintf("%s:%s:%s", ids.ToolsUID, ids.ToolsGID, ids.DockerGID)
+	}
+
+	return append(results, checkCompare(SystemIDsName, expected, actual))
+}
+
+func checkFileMatch(path, text string) (bool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[0] == text {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// checkMountedTargets maps mount points to their source device
+func checkMountedTargets() (map[string]string, error) {
+	content, err := os.ReadFile("/proc/mounts")
+	if err != nil {
+		return nil, err
+	}
+
+	mounted := make(map[string]string)
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 {
+			mounted[fields[1]] = fields[0]
+		}
+	}
+
+	return mounted, nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	DockerDaemonJSON = "/etc/docker/daemon.json"
+)
+
+// DockerConfig declares the parts of daemon.json managed by gd-tools.
+// Keys not listed here are kept as they are.
+type DockerConfig struct {
+	Channel      string              `json:"channel"`          // apt channel "stable" (default) or "test"
+	LogDriver    string              `json:"log_driver"`       // default "json-file"
+	LogMaxSize   string              `json:"log_max_size"`     // per container log file, e.g. "10m"
+	LogMaxFile   int                 `json:"log_max_file"`     // rotated files to keep, e.g. 3
+	AddressPools []DockerAddressPool `json:"address_pools"`    // default-address-pools (empty is Docker default)
+	LiveRestore  bool                `json:"live_restore"`     // keep containers running during restarts
+	IPv6         bool                `json:"ipv6"`             // IPv6 on the default bridge
+	FixedCIDRv6  string              `json:"fixed_cidr_v6"`    // e.g. "fd00:d0c::/64" (needs ipv6)
+	Mirrors      []string            `json:"registry_mirrors"` // e.g. "https://mirror.gcr.io"
+}
+
+type DockerAddressPool struct {
+	Base string `json:"base"` // e.g. "10.210.0.0/16"
+	Size int    `json:"size"` // e.g. 24
+}
+
+// dockerManagedKeys are removed from daemon.json if not declared
+var dockerManagedKeys = []string{
+	"log-driver",
+	"log-opts",
+	"default-address-pools",
+	"live-restore",
+	"ipv6",
+	"fixed-cidr-v6",
+	"registry-mirrors",
+}
+
+func DefaultDockerConfig() *DockerConfig {
+	return &DockerConfig{
+		Channel:      "stable",
+		LogDriver:    "json-file",
+		LogMaxSize:   "10m",
+		LogMaxFile:   3,
+		AddressPools: []DockerAddressPool{},
+		LiveRestore:  true,
+		IPv6:         false,
+		Mirrors:      []string{},
+	}
+}
+
+// GetChannel works without a docker section as well
+func (dc *DockerConfig) GetChannel() string {
+	if dc == nil || dc.Channel == "" {
+		return "stable"
+	}
+	return dc.Channel
+}
+
+func (dc *DockerConfig) Validate() error {
+	switch dc.GetChannel() {
+	case "stable", "test":
+	default:
+		return fmt.Errorf(Tf("docker-err-channel", dc.Channel))
+	}
+
+	for _, pool := range dc.AddressPools {
+		_, network, err := net.ParseCIDR(pool.Base)
+		if err != nil {
+			return fmt.Errorf(Tf("docker-err-pool-base", pool.Base))
+		}
+		prefix, bits := network.Mask.Size()
+		if pool.Size < prefix || pool.Size > bits {
+			return fmt.Errorf(Tf("docker-err-pool-size", pool.Base, pool.Size))
+		}
+	}
+
+	if dc.FixedCIDRv6 != "" {
+		if !dc.IPv6 {
+			return fmt.Errorf(T("docker-err-cidr-without-ipv6"))
+		}
+		if _, _, err := net.ParseCIDR(dc.FixedCIDRv6); err != nil {
+			return fmt.Errorf(Tf("docker-err-pool-base", dc.FixedCIDRv6))
+		}
+	}
+
+	for _, mirror := range dc.Mirrors {
+		if !strings.HasPrefix(mirror, "https://") && !strings.HasPrefix(mirror, "http://") {
+			return fmt.Errorf(Tf("docker-err-mirror", mirror))
+		}
+	}
+
+	return nil
+}
+
+// Merge sets the managed keys in an existing daemon.json and keeps the others
+func (dc *DockerConfig) Merge(current []byte) ([]byte, error) {
+	daemon := make(map[string]any)
+	if len(strings.TrimSpace(string(current))) > 0 {
+		if err := json.Unmarshal(current, &daemon); err != nil {
+			return nil, fmt.Errorf(Tf("docker-err-parse", DockerDaemonJSON, err))
+		}
+	}
+
+	for _, key := range dockerManagedKeys {
+		delete(daemon, key)
+	}
+
+	logDriver := dc.LogDriver
+	if logDriver == "" {
+		logDriver = "json-file"
+	}
+	daemon["log-driver"] = logDriver
+
+	// only the file based drivers know about rotation
+	if logDriver == "json-file" || logDriver == "local" {
+		logOpts := make(map[string]string)
+		if dc.LogMaxSize != "" {
+			logOpts["max-size"] = dc.LogMaxSize
+		}
+		if dc.LogMaxFile > 0 {
+			logOpts["max-file"] = strconv.Itoa(dc.LogMaxFile)
+		}
+		if len(logOpts) > 0 {
+			daemon["log-opts"] = logOpts
+		}
+	}
+
+	if len(dc.AddressPools) > 0 {
+		daemon["default-address-pools"] = dc.AddressPools
+	}
+	if dc.LiveRestore {
+		daemon["live-restore"] = true
+	}
+	if dc.IPv6 {
+		daemon["ipv6"] = true
+		if dc.FixedCIDRv6 != "" {
+			daemon["fixed-cidr-v6"] = dc.FixedCIDRv6
+		}
+	}
+	if len(dc.Mirrors) > 0 {
+		daemon["registry-mirrors"] = dc.Mirrors
+	}
+
+	// encoding/json sorts the map keys, so the output is stable
+	content, err := json.MarshalIndent(daemon, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(content, '\n'), nil
+}
+
+func (sc *SystemConfig) ConfigureDocker() error {
+	if sc.Docker == nil {
+		fmt.Println(T("docker-not-configured"))
+		return nil
+	}
+
+	if err := sc.Docker.Validate(); err != nil {
+		return err
+	}
+
+	current, err := os.ReadFile(DockerDaemonJSON)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	content, err := sc.Docker.Merge(current)
+	if err != nil {
+		return err
+	}
+	// a restart stops every container, formatting or key order are no reason for it
+	if dockerSameJSON(content, current) {
+		fmt.Println(Tf("docker-daemon-okay", DockerDaemonJSON))
+		return nil
+	}
+
+	if !sc.DryRun {
+		if err := dockerValidate(content); err != nil {
+			return err
+		}
+	}
+
+	if _, err := FileWriteIfChanged(sc.DryRun, DockerDaemonJSON, content, 0644); err != nil {
+		return err
+	}
+	fmt.Println(Tf("docker-daemon-restart", DockerDaemonJSON))
+
+	return ShellCmd(sc.DryRun, "systemctl restart docker")
+}
+
+// dockerSameJSON compares the decoded documents, not the bytes
+func dockerSameJSON(a, b []byte) bool {
+	var left, right any
+	if json.Unmarshal(a, &left) != nil || json.Unmarshal(b, &right) != nil {
+		return false
+	}
+
+	return reflect.DeepEqual(left, right)
+}
+
+// dockerValidate lets dockerd check the file before it is put in place
+func dockerValidate(content []byte) error {
+	temp, err := os.CreateTemp("", "daemon-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(content); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	// --validate exists since Docker 23, older versions are not checked
+	dockerd, err := exec.LookPath("dockerd")
+	if err != nil {
+		return nil
+	}
+	cmd := exec.Command(dockerd, "--validate", "--config-file", temp.Name())
+	cmd.Env = append(os.Environ(), "LANG=C")
+	out, err := cmd.CombinedOutput()
+	if err != nil && !strings.Contains(string(out), "unknown flag") {
+		return fmt.Errorf(Tf("docker-err-validate", strings.TrimSpace(string(out))))
+	}
+
+	return nil
+}
+
+func (sc *SystemConfig) CheckDocker() []SystemCheck {
+	if sc.Docker == nil {
+		return nil
+	}
+
+	current, _ := os.ReadFile(DockerDaemonJSON)
+	expected, err := sc.Docker.Merge(current)
+	if err != nil {
+		return []SystemCheck{checkFailed("daemon.json", "", err)}
+	}
+
+	if dockerSameJSON(expected, current) {
+		expected = current
+	}
+	results := []SystemCheck{
+		checkCompare("daemon.json", checkContent(expected), checkContent(current)),
+	}
+
+	state, _ := ShellOutput("systemctl is-active docker")
+	results = append(results, checkCompare("docker", "active", state))
+
+	return results
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	FirewallComment = "gd-tools"
+	FirewallDefault = "/etc/default/ufw"
+)
+
+type FirewallRule struct {
+	Port   string   `json:"port"`             // e.g. "OpenSSH", "25/tcp", "51820/udp"
+	Action string   `json:"action,omitempty"` // "allow" (default), "limit" or "deny"
+	From   []string `json:"from,omitempty"`   // source addresses or networks (default anywhere)
+}
+
+type FirewallConfig struct {
+	IPv6  *bool          `json:"ipv6,omitempty"` // IPV6= in /etc/default/ufw (default yes)
+	Rules []FirewallRule `json:"rules"`
+}
+
+// ufwRule is one line of "ufw status numbered"
+type ufwRule struct {
+	Number  int
+	To      string
+	Action  string
+	From    string
+	Comment string
+}
+
+func (r ufwRule) Key() string {
+	return r.To + "|" + r.Action + "|" + r.From
+}
+
+var ufwRuleRE = regexp.MustCompile(`^\[\s*(\d+)\]\s+(.+?)\s{2,}(ALLOW|DENY|REJECT|LIMIT)(?: IN| OUT| FWD)?\s+(.+?)\s*(?:#\s*(.*))?$`)
+
+func DefaultFirewallRules() []FirewallRule {
+	return []FirewallRule{
+		{Port: "OpenSSH"},
+		{Port: "Nginx Full"},
+	}
+}
+
+func (fw FirewallConfig) IPv6Enabled() bool {
+	return fw.IPv6 == nil || *fw.IPv6
+}
+
+func (fw FirewallConfig) GetRules() []FirewallRule {
+	if len(fw.Rules) == 0 {
+		return DefaultFirewallRules()
+	}
+	return fw.Rules
+}
+
+func (sc *SystemConfig) ActivateFirewall() error {
+	fw := sc.Firewall
+
+	ipv6 := "no"
+	if fw.IPv6Enabled() {
+		ipv6 = "yes"
+	}
+	changed, err := FileSetVariable(sc.DryRun, FirewallDefault, "IPV6", ipv6)
+	if err != nil {
+		return err
+	}
+
+	active, err := ShellMatch("ufw status", "Status: active")
+	if err != nil {
+		return err
+	}
+	if !active {
+		// an inactive ufw does not list its rules, so add everything first
+		for _, rule := range fw.GetRules() {
+			if err := ShellCmds(sc.DryRun, rule.Commands()); err != nil {
+				return err
+			}
+		}
+		if err := ShellCmd(sc.DryRun, "ufw enable"); err != nil {
+			return err
+		}
+		if sc.DryRun {
+			return nil
+		}
+	} else if changed {
+		if err := ShellCmd(sc.DryRun, "ufw reload"); err != nil {
+			return err
+		}
+	}
+
+	current, err := ufwStatusNumbered()
+	if err != nil {
+		return err
+	}
+	present := make(map[string]bool)
+	for _, rule := range current {
+		present[rule.Key()] = true
+	}
+
+	// add before delete, so there is no gap for SSH
+	wanted := make(map[string]bool)
+	for _, rule := range fw.GetRules() {
+		missing := false
+		for _, key := range rule.Keys(fw.IPv6Enabled()) {
+			wanted[key] = true
+			if !present[key] {
+				missing = true
+			}
+		}
+		if !missing {
+			fmt.Println(Tf("firewall-rule-okay", rule.String()))
+			continue
+		}
+		if err := ShellCmds(sc.DryRun, rule.Commands()); err != nil {
+			return err
+		}
+	}
+
+	// delete from the highest number, because ufw renumbers after each delete
+	stale := ufwStaleRules(current, wanted)
+	sort.Slice(stale, func(i, j int) bool {
+		return stale[i].Number > stale[j].Number
+	})
+	for _, rule := range stale {
+		fmt.Println(Tf("firewall-rule-remove", rule.To, rule.Action, rule.From))
+		if err := ShellCmd(sc.DryRun, fmt.Sprintf("ufw --force delete %d", rule.Number)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (sc *SystemConfig) CheckFirewall() []SystemCheck {
+	fw := sc.Firewall
+
+	output, err := ShellOutput("ufw status")
+	if err != nil {
+		return []SystemCheck{checkFailed("ufw", "active", err)}
+	}
+	status := "inactive"
+	if strings.Contains(output, "Status: active") {
+		status = "active"
+	}
+	results := []SystemCheck{checkCompare("ufw", "active", status)}
+
+	ipv6 := "no"
+	if fw.IPv6Enabled() {
+		ipv6 = "yes"
+	}
+	if actual, err := FileGetVariable(FirewallDefault, "IPV6"); err != nil {
+		results = append(results, checkFailed("IPV6", ipv6, err))
+	} else {
+		results = append(results, checkCompare("IPV6", ipv6, actual))
+	}
+
+	current, err := ufwStatusNumbered()
+	if err != nil {
+		return append(results, checkFailed("rules", "", err))
+	}
+	present := make(map[string]bool)
+	for _, rule := range current {
+		present[rule.Key()] = true
+	}
+
+	wanted := make(map[string]bool)
+	for _, rule := range fw.GetRules() {
+		for _, key := range rule.Keys(fw.IPv6Enabled()) {
+			wanted[key] = true
+			item := strings.ReplaceAll(key, "|", " ")
+			results = append(results, checkCompare(item, checkPresent(true), checkPresent(present[key])))
+		}
+	}
+	for _, rule := range ufwStaleRules(current, wanted) {
+		item := strings.ReplaceAll(rule.Key(), "|", " ")
+		results = append(results, checkCompare(item, checkPresent(false), checkPresent(true)))
+	}
+
+	return results
+}
+
+func (r FirewallRule) String() string {
+	text := r.action() + " " + r.Port
+	if len(r.From) > 0 {
+		text += " from " + strings.Join(r.From, ",")
+	}
+	return text
+}
+
+func (r FirewallRule) action() string {
+	if r.Action == "" {
+		return "allow"
+	}
+	return strings.ToLower(r.Action)
+}
+
+func (r FirewallRule) isApp() bool {
+	return r.Port != "" && (r.Port[0] < '0' || r.Port[0] > '9')
+}
+
+// Commands returns the ufw commands that add this rule (ufw skips existing ones)
+func (r FirewallRule) Commands() []string {
+	port := strings.ReplaceAll(r.Port, " ", "_#_")
+	suffix := "comment " + FirewallComment
+
+	if len(r.From) == 0 {
+		return []string{fmt.Sprintf("ufw %s %s %s", r.action(), port, suffix)}
+	}
+
+	target := "app " + port
+	if !r.isApp() {
+		number, proto, hasProto := strings.Cut(r.Port, "/")
+		target = "port " + number
+		if hasProto {
+			target += " proto " + proto
+		}
+	}
+
+	var cmds []string
+	for _, from := range r.From {
+		cmds = append(cmds, fmt.Sprintf("ufw %s from %s to any %s %s", r.action(), from, target, suffix))
+	}
+	return cmds
+}
+
+// Keys returns the rule as ufw lists it, once per address family
+func (r FirewallRule) Keys(ipv6 bool) []string {
+	action := strings.ToUpper(r.action())
+
+	if len(r.From) == 0 {
+		keys := []string{r.Port + "|" + action + "|Anywhere"}
+		if ipv6 {
+			keys = append(keys, r.Port+" (v6)|"+action+"|Anywhere (v6)")
+		}
+		return keys
+	}
+
+	var keys []string
+	for _, from := range r.From {
+		from = firewallNormalize(from)
+		to := r.Port
+		if strings.Contains(from, ":") {
+			to += " (v6)"
+		}
+		keys = append(keys, to+"|"+action+"|"+from)
+	}
+	return keys
+}
+
+// firewallNormalize turns "10.1.2.3/24" into "10.1.2.0/24" like ufw does
+func firewallNormalize(from string) string {
+	if _, network, err := net.ParseCIDR(from); err == nil {
+		return network.String()
+	}
+	return from
+}
+
+func ufwStatusNumbered() ([]ufwRule, error) {
+	output, err := ShellOutput("ufw status numbered")
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []ufwRule
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		match := ufwRuleRE.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+		if match == nil {
+			continue
+		}
+		number, _ := strconv.Atoi(match[1])
+		rules = append(rules, ufwRule{
+			Number:  number,
+			To:      strings.TrimSpace(match[2]),
+			Action:  match[3],
+			From:    strings.TrimSpace(match[4]),
+			Comment: strings.TrimSpace(match[5]),
+		})
+	}
+
+	return rules, scanner.Err()
+}
+
+// ufwStaleRules are rules added by gd-tools that are no longer declared
+func ufwStaleRules(current []ufwRule, wanted map[string]bool) []ufwRule {
+	var stale []ufwRule
+	for _, rule := range current {
+		if rule.Comment == FirewallComment && !wanted[rule.Key()] {
+			stale = append(stale, rule)
+		}
+	}
+	return stale
+}
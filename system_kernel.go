@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+const (
+	KernelSysctlConf = "/etc/sysctl.d/90-gd-tools.conf"
+	KernelLimitsConf = "/etc/security/limits.d/90-gd-tools.conf"
+)
+
+// KernelConfig holds sysctl settings and PAM limits for the stacks on this host.
+// Containers do not read limits.d, use ulimits in the compose file for them.
+type KernelConfig struct {
+	Sysctl map[string]string `json:"sysctl"` // e.g. "vm.max_map_count": "262144"
+	Limits []KernelLimit     `json:"limits"` // lines for limits.d
+}
+
+type KernelLimit struct {
+	Domain string `json:"domain"` // e.g. "*", "gd-tools" or "@docker"
+	Type   string `json:"type"`   // "soft", "hard" or "-" for both
+	Item   string `json:"item"`   // e.g. "nofile", "nproc", "memlock"
+	Value  string `json:"value"`  // e.g. "65536" or "unlimited"
+}
+
+var (
+	kernelKeyRE     = regexp.MustCompile(`^[a-z0-9_\-]+(\.[a-zA-Z0-9_\-]+)+$`)
+	kernelLimitItem = []string{"core", "data", "fsize", "memlock", "nofile", "rss", "stack", "cpu",
+		"nproc", "as", "maxlogins", "maxsyslogins", "priority", "locks", "sigpending",
+		"msgqueue", "nice", "rtprio"}
+)
+
+func DefaultKernelConfig() *KernelConfig {
+	return &KernelConfig{
+		Sysctl: map[string]string{},
+		Limits: []KernelLimit{},
+	}
+}
+
+func (kc *KernelConfig) Validate(sc *SystemConfig) error {
+	for key, value := range kc.Sysctl {
+		if !kernelKeyRE.MatchString(key) || strings.TrimSpace(value) == "" {
+			return fmt.Errorf(Tf("kernel-err-sysctl", key, value))
+		}
+		// swappiness has its own setting and drop-in
+		if key == "vm.swappiness" && sc.Swap != nil && sc.Swap.Swappiness > 0 {
+			return fmt.Errorf(T("kernel-err-swappiness"))
+		}
+	}
+
+	for _, limit := range kc.Limits {
+		if limit.Domain == "" || strings.ContainsAny(limit.Domain, " \t") ||
+			!slices.Contains([]string{"soft", "hard", "-"}, limit.Type) ||
+			!slices.Contains(kernelLimitItem, limit.Item) ||
+			limit.Value == "" || strings.ContainsAny(limit.Value, " \t") {
+			return fmt.Errorf(Tf("kernel-err-limit", limit.String()))
+		}
+	}
+
+	return nil
+}
+
+func (kc *KernelConfig) sysctlKeys() []string {
+	var keys []string
+	for key := range kc.Sysctl {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// RenderSysctl returns the drop-in, or nil if there is nothing to set
+func (kc *KernelConfig) RenderSysctl() []byte {
+	if len(kc.Sysctl) == 0 {
+		return nil
+	}
+
+	var b strings.Builder
+	b.WriteString("# managed by gd-tools, see gd-tools-system.json\n")
+	for _, key := range kc.sysctlKeys() {
+		fmt.Fprintf(&b, "%s = %s\n", key, kc.Sysctl[key])
+	}
+
+	return []byte(b.String())
+}
+
+// RenderLimits returns the drop-in, or nil if there is nothing to set
+func (kc *KernelConfig) RenderLimits() []byte {
+	if len(kc.Limits) == 0 {
+		return nil
+	}
+
+	var b strings.Builder
+	b.WriteString("# managed by gd-tools, see gd-tools-system.json\n")
+	for _, limit := range kc.Limits {
+		b.WriteString(limit.String() + "\n")
+	}
+
+	return []byte(b.String())
+}
+
+func (l KernelLimit) String() string {
+	return fmt.Sprintf("%s %s %s %s", l.Domain, l.Type, l.Item, l.Value)
+}
+
+func (sc *SystemConfig) ConfigureKernel() error {
+	if sc.Kernel == nil {
+		fmt.Println(T("kernel-not-configured"))
+		return nil
+	}
+	kernel := sc.Kernel
+
+	if err := kernel.Validate(sc); err != nil {
+		return err
+	}
+
+	changed, err := kernelWriteDropIn(sc.DryRun, KernelSysctlConf, kernel.RenderSysctl())
+	if err != nil {
+		return err
+	}
+	if changed {
+		if err := ShellCmd(sc.DryRun, "sysctl --system"); err != nil {
+			return err
+		}
+	} else {
+		fmt.Println(Tf("kernel-okay", KernelSysctlConf))
+	}
+
+	// limits.d is read by pam_limits at the next login
+	changed, err = kernelWriteDropIn(sc.DryRun, KernelLimitsConf, kernel.RenderLimits())
+	if err != nil {
+		return err
+	}
+	if changed {
+		fmt.Println(Tf("kernel-limits-login", KernelLimitsConf))
+	} else {
+		fmt.Println(Tf("kernel-okay", KernelLimitsConf))
+	}
+
+	if sc.DryRun {
+		return nil
+	}
+
+	// some keys cannot be set (e.g. in a container), tell about it
+	for _, key := range kernel.sysctlKeys() {
+		actual, err := kernelRunning(key)
+		if err != nil {
+			fmt.Println(Tf("kernel-warn-running", key, kernel.Sysctl[key], err.Error()))
+		} else if actual != kernelNormalize(kernel.Sysctl[key]) {
+			fmt.Println(Tf("kernel-warn-running", key, kernel.Sysctl[key], actual))
+		}
+	}
+
+	return nil
+}
+
+// kernelWriteDropIn writes content, or removes the file if content is nil
+func kernelWriteDropIn(dryRun bool, path string, content []byte) (bool, error) {
+	if content != nil {
+		return FileWriteIfChanged(dryRun, path, content, 0644)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return false, nil
+	}
+
+	return true, FileRemove(dryRun, path)
+}
+
+func (sc *SystemConfig) CheckKernel() []SystemCheck {
+	if sc.Kernel == nil {
+		return nil
+	}
+	kernel := sc.Kernel
+
+	var results []SystemCheck
+	for _, entry := range []struct {
+		path    string
+		content []byte
+	}{
+		{KernelSysctlConf, kernel.RenderSysctl()},
+		{KernelLimitsConf, kernel.RenderLimits()},
+	} {
+		actual, _ := os.ReadFile(entry.path)
+		results = append(results, checkCompare(filepath.Base(entry.path),
+			checkContent(entry.content), checkContent(actual)))
+	}
+
+	for _, key := range kernel.sysctlKeys() {
+		expected := kernelNormalize(kernel.Sysctl[key])
+		actual, err := kernelRunning(key)
+		if err != nil {
+			results = append(results, checkFailed(key, expected, err))
+			continue
+		}
+		results = append(results, checkCompare(key, expected, actual))
+	}
+
+	return results
+}
+
+// kernelRunning reads the live value from /proc/sys
+func kernelRunning(key string) (string, error) {
+	path := filepath.Join("/proc/sys", strings.ReplaceAll(key, ".", "/"))
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return kernelNormalize(string(content)), nil
+}
+
+// kernelNormalize makes "4096 87380 6291456" and "4096\t87380\t6291456" equal
+func kernelNormalize(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	KeysManagedBegin = "# --- gd-tools: managed keys from gd-tools-system.json, do not edit ---"
+	KeysLocalBegin   = "# --- gd-tools: local keys, not managed by gd-tools ---"
+)
+
+// AdminKey is one team member with the accounts they may log in as
+type AdminKey struct {
+	Name  string   `json:"name"`  // e.g. "volker"
+	Keys  []string `json:"keys"`  // public keys in authorized_keys format
+	Users []string `json:"users"` // e.g. ["root", "gd-tools"]
+}
+
+// KeysTargetUsers lists every account that gets an authorized_keys file
+func (sc *SystemConfig) KeysTargetUsers() []string {
+	targets := []string{"root", "gd-tools"}
+	for _, admin := range sc.Admins {
+		for _, name := range admin.Users {
+			if !slices.Contains(targets, name) {
+				targets = append(targets, name)
+			}
+		}
+	}
+
+	return targets
+}
+
+// KeysManaged renders the managed key lines for one account
+func (sc *SystemConfig) KeysManaged(userName string) ([]string, error) {
+	var lines []string
+	for _, admin := range sc.Admins {
+		if !slices.Contains(admin.Users, userName) {
+			continue
+		}
+		for _, key := range admin.Keys {
+			pubKey, _, options, _, err := ssh.ParseAuthorizedKey([]byte(key))
+			if err != nil {
+				return nil, fmt.Errorf(Tf("keys-err-invalid", admin.Name, err))
+			}
+			line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pubKey)))
+			// from="…", command="…" and the like restrict the key, dropping them would widen access
+			if len(options) > 0 {
+				line = strings.Join(options, ",") + " " + line
+			}
+			lines = append(lines, line+" "+admin.Name)
+		}
+	}
+	sort.Strings(lines)
+
+	return lines, nil
+}
+
+func (sc *SystemConfig) ManageKeys() error {
+	if len(sc.Admins) == 0 {
+		fmt.Println(T("keys-not-configured"))
+		return nil
+	}
+	for _, key := range sc.KeysKeep {
+		if keysMaterial(key) == "" {
+			return fmt.Errorf(Tf("keys-err-keep-invalid", key))
+		}
+	}
+
+	for _, userName := range sc.KeysTargetUsers() {
+		account, err := user.Lookup(userName)
+		if err != nil {
+			if sc.DryRun {
+				fmt.Println("[dry]", Tf("keys-err-user", userName, err))
+				continue
+			}
+			return fmt.Errorf(Tf("keys-err-user", userName, err))
+		}
+
+		managed, err := sc.KeysManaged(userName)
+		if err != nil {
+			return err
+		}
+
+		path := filepath.Join(account.HomeDir, ".ssh", "authorized_keys")
+		current, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		local := keysLocalSection(string(current), managed)
+		if !strings.Contains(string(current), KeysManagedBegin) {
+			if userName == "gd-tools" {
+				local = nil // only a copy of root's keys made by AddToolsUser
+			} else if local, err = sc.keysFirstRun(path, local); err != nil {
+				return err
+			}
+		}
+		if userName == "root" && len(managed)+keysCount(local) == 0 {
+			return fmt.Errorf(T("keys-err-root-empty"))
+		}
+		if n := keysCount(local); n > 0 {
+			fmt.Println(Tf("keys-local-kept", n, path))
+		}
+
+		content := keysRender(managed, local)
+		changed, err := FileWriteIfChanged(sc.DryRun, path, []byte(content), 0600)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			fmt.Println(Tf("keys-okay", path))
+			continue
+		}
+
+		if sc.DryRun {
+			continue
+		}
+		uid, _ := strconv.Atoi(account.Uid)
+		gid, _ := strconv.Atoi(account.Gid)
+		if err := os.Chown(filepath.Dir(path), uid, gid); err != nil {
+			return err
+		}
+		if err := os.Chmod(filepath.Dir(path), 0700); err != nil {
+			return err
+		}
+		if err := os.Chown(path, uid, gid); err != nil {
+			return err
+		}
+		fmt.Println(Tf("keys-updated", path, len(managed)))
+	}
+
+	return nil
+}
+
+// keysFirstRun decides about the keys found before gd-tools managed the file.
+// Only those in keys_keep become local, the rest is removed after confirmation.
+func (sc *SystemConfig) keysFirstRun(path string, found []string) ([]string, error) {
+	var kept, undeclared []string
+	for _, line := range found {
+		if strings.HasPrefix(line, "#") || keysDeclared(line, sc.keysKeep()) {
+			kept = append(kept, line)
+		} else {
+			undeclared = append(undeclared, line)
+		}
+	}
+	if len(undeclared) == 0 {
+		return kept, nil
+	}
+
+	fmt.Println(Tf("keys-undeclared", len(undeclared), path))
+	for _, line := range undeclared {
+		fmt.Println("  " + keysDescribe(line))
+	}
+	if sc.DryRun {
+		fmt.Println("[dry]", T("keys-undeclared-dry"))
+		return kept, nil
+	}
+
+	fmt.Print(T("keys-undeclared-prompt"))
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if strings.ToLower(strings.TrimSpace(answer)) != "y" {
+		return nil, fmt.Errorf(Tf("keys-err-not-confirmed", path))
+	}
+
+	return kept, nil
+}
+
+// keysKeep returns keys_keep as lines keysDeclared can compare with
+func (sc *SystemConfig) keysKeep() []string {
+	var lines []string
+	for _, key := range sc.KeysKeep {
+		if material := keysMaterial(key); material != "" {
+			lines = append(lines, material)
+		}
+	}
+
+	return lines
+}
+
+// keysDescribe shortens a key line to type, fingerprint and comment
+func keysDescribe(line string) string {
+	pubKey, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		return line
+	}
+
+	return strings.TrimSpace(pubKey.Type() + " " + ssh.FingerprintSHA256(pubKey) + " " + comment)
+}
+
+func (sc *SystemConfig) CheckKeys() []SystemCheck {
+	if len(sc.Admins) == 0 {
+		return nil
+	}
+
+	var results []SystemCheck
+	for _, userName := range sc.KeysTargetUsers() {
+		account, err := user.Lookup(userName)
+		if err != nil {
+			results = append(results, checkFailed(userName, "", err))
+			continue
+		}
+		managed, err := sc.KeysManaged(userName)
+		if err != nil {
+			results = append(results, checkFailed(userName, "", err))
+			continue
+		}
+
+		path := filepath.Join(account.HomeDir, ".ssh", "authorized_keys")
+		current, _ := os.ReadFile(path)
+		actual := keysManagedSection(string(current))
+
+		results = append(results, checkCompare(userName,
+			checkContent([]byte(strings.Join(managed, "\n"))),
+			checkContent([]byte(strings.Join(actual, "\n")))))
+	}
+
+	return results
+}
+
+func keysRender(managed []string, local []string) string {
+	var b strings.Builder
+
+	b.WriteString(KeysManagedBegin + "\n")
+	for _, line := range managed {
+		b.WriteString(line + "\n")
+	}
+	b.WriteString("\n" + KeysLocalBegin + "\n")
+	for _, line := range local {
+		b.WriteString(line + "\n")
+	}
+
+	return b.String()
+}
+
+// keysManagedSection returns the lines between the managed and the local marker
+func keysManagedSection(content string) []string {
+	var lines []string
+	inManaged := false
+	for _, line := range strings.Split(content, "\n") {
+		switch strings.TrimSpace(line) {
+		case KeysManagedBegin:
+			inManaged = true
+			continue
+		case KeysLocalBegin:
+			inManaged = false
+			continue
+		}
+		if inManaged && strings.TrimSpace(line) != "" {
+			lines = append(lines, strings.TrimSpace(line))
+		}
+	}
+
+	return lines
+}
+
+// keysLocalSection keeps everything gd-tools does not manage.
+// Before the first run there are no markers, every key not declared is returned for keysFirstRun.
+func keysLocalSection(content string, managed []string) []string {
+	hasMarkers := strings.Contains(content, KeysManagedBegin)
+
+	var lines []string
+	inManaged := false
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch trimmed {
+		case KeysManagedBegin:
+			inManaged = true
+			continue
+		case KeysLocalBegin:
+			inManaged = false
+			continue
+		case "":
+			continue
+		}
+		if inManaged || (!hasMarkers && keysDeclared(trimmed, managed)) {
+			continue
+		}
+		lines = append(lines, trimmed)
+	}
+
+	return lines
+}
+
+// keysDeclared compares only type and key material, options and comments may differ
+func keysDeclared(line string, managed []string) bool {
+	wanted := keysMaterial(line)
+	if wanted == "" {
+		return false
+	}
+
+	for _, entry := range managed {
+		if keysMaterial(entry) == wanted {
+			return true
+		}
+	}
+
+	return false
+}
+
+// keysMaterial returns type and key of an authorized_keys line, empty if it is none
+func keysMaterial(line string) string {
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pubKey)))
+}
+
+func keysCount(lines []string) int {
+	count := 0
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "#") {
+			count++
+		}
+	}
+
+	return count
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+const (
+	SSHDropIn        = "/etc/ssh/sshd_config.d/10-gd-tools.conf"
+	SSHFail2BanJail  = "/etc/fail2ban/jail.d/gd-tools.local"
+	SSHRollbackUnit  = "gd-tools-ssh-rollback"
+	SSHRollbackDelay = 5 // minutes
+)
+
+type SSHConfig struct {
+	Port            int      `json:"port"`              // default 22
+	PasswordAuth    bool     `json:"password_auth"`     // default off
+	PermitRootLogin string   `json:"permit_root_login"` // "prohibit-password" or "forced-commands-only"
+	AllowUsers      []string `json:"allow_users"`       // empty means every user with a key
+	Fail2Ban        bool     `json:"fail2ban"`          // jails for sshd and nginx
+	Fail2BanIgnore  []string `json:"fail2ban_ignore"`   // never banned, e.g. office IPs
+	RollbackMinutes int      `json:"rollback_minutes"`  // revert unless confirmed (default 5)
+}
+
+func DefaultSSHConfig() *SSHConfig {
+	return &SSHConfig{
+		Port:            22,
+		PasswordAuth:    false,
+		PermitRootLogin: "prohibit-password",
+		AllowUsers:      []string{},
+		Fail2Ban:        true,
+		Fail2BanIgnore:  []string{},
+		RollbackMinutes: SSHRollbackDelay,
+	}
+}
+
+func (sc *SystemConfig) HardenSSH() error {
+	if sc.SSH == nil {
+		fmt.Println(T("ssh-not-configured"))
+		return nil
+	}
+	ssh := sc.SSH
+
+	if err := ssh.Validate(sc); err != nil {
+		return err
+	}
+
+	dropIn, err := ssh.Render()
+	if err != nil {
+		return err
+	}
+
+	previous, err := os.ReadFile(SSHDropIn)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	changed, err := FileWriteIfChanged(sc.DryRun, SSHDropIn, dropIn, 0644)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		fmt.Println(Tf("ssh-dropin-okay", SSHDropIn))
+	} else if !sc.DryRun {
+		if err := sshActivate(ssh, previous); err != nil {
+			return err
+		}
+	} else {
+		if err := ShellCmds(true, append([]string{"sshd -t"}, sshApplyCmds()...)); err != nil {
+			return err
+		}
+	}
+
+	if ssh.Fail2Ban {
+		if err := ssh.setupFail2Ban(sc.DryRun); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Validate refuses settings that would lock out root or gd-tools
+func (ssh *SSHConfig) Validate(sc *SystemConfig) error {
+	switch ssh.PermitRootLogin {
+	case "", "prohibit-password", "forced-commands-only":
+	default:
+		// "no" breaks deploy, "yes" would allow root passwords
+		return fmt.Errorf(Tf("ssh-err-root-login", ssh.PermitRootLogin))
+	}
+
+	if len(ssh.AllowUsers) > 0 {
+		for _, needed := range []string{"root", "gd-tools"} {
+			if !slices.Contains(ssh.AllowUsers, needed) {
+				return fmt.Errorf(Tf("ssh-err-allow-users", needed))
+			}
+		}
+	}
+
+	if !ssh.PasswordAuth {
+		if keys, _ := sshCountKeys("/root/.ssh/authorized_keys"); keys == 0 {
+			return fmt.Errorf(T("ssh-err-no-keys"))
+		}
+	}
+
+	if port := ssh.port(); port != 22 {
+		found := false
+		for _, rule := range sc.Firewall.GetRules() {
+			if rule.Port == strconv.Itoa(port) || rule.Port == fmt.Sprintf("%d/tcp", port) {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf(Tf("ssh-err-port-firewall", port))
+		}
+	}
+
+	return nil
+}
+
+func (ssh *SSHConfig) Render() ([]byte, error) {
+	passwordAuth := "no"
+	if ssh.PasswordAuth {
+		passwordAuth = "yes"
+	}
+	permitRoot := ssh.PermitRootLogin
+	if permitRoot == "" {
+		permitRoot = "prohibit-password"
+	}
+
+	data := struct {
+		Port            int
+		PasswordAuth    string
+		PermitRootLogin string
+		AllowUsers      string
+	}{
+		Port:            ssh.port(),
+		PasswordAuth:    passwordAuth,
+		PermitRootLogin: permitRoot,
+		AllowUsers:      strings.Join(ssh.AllowUsers, " "),
+	}
+
+	return TemplateParse("sshd-gd-tools.conf", data)
+}
+
+// sshActivate validates and reloads sshd, with a timer that reverts unless confirmed
+func sshActivate(ssh *SSHConfig, previous []byte) error {
+	// restore goes through the backup set, a failure must not go unnoticed
+	restore := func() error {
+		var err error
+		if previous == nil {
+			err = FileRemove(false, SSHDropIn)
+		} else {
+			err = FileWriteAtomic(SSHDropIn, previous, 0644)
+		}
+		if err != nil {
+			return fmt.Errorf(Tf("ssh-err-restore", err))
+		}
+		return nil
+	}
+
+	if err := ShellCmd(false, "sshd -t"); err != nil {
+		return errors.Join(fmt.Errorf(Tf("ssh-err-validate", err)), restore())
+	}
+
+	// the rollback script puts the old drop-in back and applies it
+	apply := strings.Join(sshApplyCmds(), " && ")
+	backup := SSHDropIn + ".gd-tools-bak"
+	script := fmt.Sprintf("rm -f %s && %s", SSHDropIn, apply)
+	if previous != nil {
+		if err := os.WriteFile(backup, previous, 0644); err != nil {
+			return errors.Join(err, restore())
+		}
+		script = fmt.Sprintf("mv %s %s && %s", backup, SSHDropIn, apply)
+	}
+
+	minutes := ssh.RollbackMinutes
+	if minutes <= 0 {
+		minutes = SSHRollbackDelay
+	}
+	exec.Command("systemctl", "stop", SSHRollbackUnit+".timer").Run()
+	timer := exec.Command("systemd-run", "--unit="+SSHRollbackUnit,
+		fmt.Sprintf("--on-active=%dm", minutes), "/bin/sh", "-c", script)
+	if out, err := timer.CombinedOutput(); err != nil {
+		return errors.Join(fmt.Errorf(Tf("ssh-err-rollback", strings.TrimSpace(string(out)))), restore())
+	}
+
+	if err := ShellCmds(false, sshApplyCmds()); err != nil {
+		return err
+	}
+
+	fmt.Println(Tf("ssh-confirm-prompt", minutes))
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		// no terminal left to answer, the timer takes care of it
+		fmt.Println(Tf("ssh-confirm-missing", minutes))
+		return fmt.Errorf(T("ssh-err-not-confirmed"))
+	}
+	if strings.ToLower(strings.TrimSpace(answer)) != "y" {
+		exec.Command("systemctl", "stop", SSHRollbackUnit+".timer").Run()
+		rollback := exec.Command("/bin/sh", "-c", script)
+		if out, err := rollback.CombinedOutput(); err != nil {
+			return fmt.Errorf(Tf("ssh-err-rollback-now", strings.TrimSpace(string(out))))
+		}
+		fmt.Println(T("ssh-rolled-back"))
+		return fmt.Errorf(T("ssh-err-not-confirmed"))
+	}
+
+	if err := ShellCmd(false, "systemctl stop "+SSHRollbackUnit+".timer"); err != nil {
+		return fmt.Errorf(Tf("ssh-err-rollback", err.Error()))
+	}
+	os.Remove(backup)
+	fmt.Println(T("ssh-confirmed"))
+
+	return nil
+}
+
+func (ssh *SSHConfig) setupFail2Ban(dryRun bool) error {
+	if err := PackagesEnsure(dryRun, []string{"fail2ban"}); err != nil {
+		return err
+	}
+
+	data := struct {
+		Port     int
+		BanTime  string
+		MaxRetry int
+		IgnoreIP string
+	}{
+		Port:     ssh.port(),
+		BanTime:  "1h",
+		MaxRetry: 5,
+		IgnoreIP: strings.Join(ssh.Fail2BanIgnore, " "),
+	}
+	content, err := TemplateParse("fail2ban-gd-tools.local", data)
+	if err != nil {
+		return err
+	}
+
+	changed, err := FileWriteIfChanged(dryRun, SSHFail2BanJail, content, 0644)
+	if err != nil {
+		return err
+	}
+
+	if err := SystemService(dryRun, "fail2ban"); err != nil {
+		return err
+	}
+	if changed {
+		return ShellCmd(dryRun, "systemctl reload fail2ban")
+	}
+
+	return nil
+}
+
+func (sc *SystemConfig) CheckSSH() []SystemCheck {
+	if sc.SSH == nil {
+		return nil
+	}
+
+	var results []SystemCheck
+	expected, err := sc.SSH.Render()
+	if err != nil {
+		return []SystemCheck{checkFailed(filepath.Base(SSHDropIn), "", err)}
+	}
+	actual, _ := os.ReadFile(SSHDropIn)
+	results = append(results, checkCompare(filepath.Base(SSHDropIn),
+		checkContent(expected), checkContent(actual)))
+
+	port := strconv.Itoa(sc.SSH.port())
+	if effective, err := ShellOutput("sshd -T"); err != nil {
+		results = append(results, checkFailed("sshd -T", "", err))
+	} else {
+		results = append(results, checkCompare("port", port, sshEffective(effective, "port")))
+	}
+	// sshd -T only reads the files, with ssh.socket the old port may still be open
+	if listening, err := sshListening(port); err != nil {
+		results = append(results, checkFailed("listen", port, err))
+	} else {
+		results = append(results, checkCompare("listen", port, listening))
+	}
+
+	if sc.SSH.Fail2Ban {
+		state, _ := ShellOutput("systemctl is-active fail2ban")
+		results = append(results, checkCompare("fail2ban", "active", state))
+	}
+
+	return results
+}
+
+// sshSocketActivated is true on Ubuntu 22.10 and later, where ssh.socket holds the port
+func sshSocketActivated() bool {
+	state, _ := ShellOutput("systemctl is-enabled ssh.socket")
+	return state == "enabled"
+}
+
+// sshApplyCmds makes sshd use the current config, a reload misses a new port under ssh.socket.
+// The socket refuses to start while ssh.service runs, open sessions survive the stop.
+func sshApplyCmds() []string {
+	if sshSocketActivated() {
+		return []string{"systemctl daemon-reload", "systemctl stop ssh",
+			"systemctl restart ssh.socket", "systemctl start ssh"}
+	}
+	return []string{"systemctl reload ssh"}
+}
+
+// sshListening returns port if something listens on it, else "-"
+func sshListening(port string) (string, error) {
+	output, err := ShellOutput("ss -Hltn")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 4 && strings.HasSuffix(fields[3], ":"+port) {
+			return port, nil
+		}
+	}
+	return "-", nil
+}
+
+func (ssh *SSHConfig) port() int {
+	if ssh.Port <= 0 {
+		return 22
+	}
+	return ssh.Port
+}
+
+func sshEffective(output, key string) string {
+	for _, line := range strings.Split(output, "\n") {
+		if value, ok := strings.CutPrefix(line, key+" "); ok {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+func sshCountKeys(path string) (int, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			count++
+		}
+	}
+
+	return count, nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+const (
+	SystemStateName = "gd-tools-system-state.json"
+)
+
+// SystemStep is one named provisioning step of "gd-tools system"
+type SystemStep struct {
+	Name   string // short name for --only and --skip
+	Method string // name of the SystemConfig method
+	Run    func(sc *SystemConfig) error
+	Check  func(sc *SystemConfig) []SystemCheck
+}
+
+// the order of this list is the order of execution
+var systemSteps = []SystemStep{
+	{"timezone", "SetTimeZone", (*SystemConfig).SetTimeZone, (*SystemConfig).CheckTimeZone},
+	{"ntp", "SyncTime", (*SystemConfig).SyncTime, (*SystemConfig).CheckTime},
+	{"locale", "SetLocale", (*SystemConfig).SetLocale, (*SystemConfig).CheckLocale},
+	{"hostname", "SetHostName", (*SystemConfig).SetHostName, (*SystemConfig).CheckHostName},
+	{"swap", "AddSwapSpace", (*SystemConfig).AddSwapSpace, (*SystemConfig).CheckSwapSpace},
+	{"kernel", "ConfigureKernel", (*SystemConfig).ConfigureKernel, (*SystemConfig).CheckKernel},
+	{"docker", "AddDockerRepo", (*SystemConfig).AddDockerRepo, (*SystemConfig).CheckDockerRepo},
+	{"packages", "InstallPackages", (*SystemConfig).InstallPackages, (*SystemConfig).CheckPackages},
+	{"daemon", "ConfigureDocker", (*SystemConfig).ConfigureDocker, (*SystemConfig).CheckDocker},
+	{"updates", "ConfigureUpdates", (*SystemConfig).ConfigureUpdates, (*SystemConfig).CheckUpdates},
+	{"mounts", "SetupMounts", (*SystemConfig).SetupMounts, (*SystemConfig).CheckMounts},
+	{"firewall", "ActivateFirewall", (*SystemConfig).ActivateFirewall, (*SystemConfig).CheckFirewall},
+	{"user", "AddToolsUser", (*SystemConfig).AddToolsUser, (*SystemConfig).CheckToolsUser},
+	{"keys", "ManageKeys", (*SystemConfig).ManageKeys, (*SystemConfig).CheckKeys},
+	{"ssh", "HardenSSH", (*SystemConfig).HardenSSH, (*SystemConfig).CheckSSH},
+	{"collect", "CollectData", (*SystemConfig).CollectData, (*SystemConfig).CheckCollectData},
+}
+
+// SystemState records the completed steps of the last run
+type SystemState struct {
+	ConfigHash string               `json:"config_hash"`
+	Completed  map[string]time.Time `json:"completed"`
+}
+
+func SystemStepNames() []string {
+	var names []string
+	for _, step := range systemSteps {
+		names = append(names, step.Name)
+	}
+
+	return names
+}
+
+// SystemSelectSteps applies --only and --skip to the registry
+func SystemSelectSteps(only, skip []string) ([]SystemStep, error) {
+	known := make(map[string]bool)
+	for _, step := range systemSteps {
+		known[step.Name] = true
+	}
+
+	for _, name := range append(append([]string{}, only...), skip...) {
+		if !known[name] {
+			msg := Tf("system-err-unknown-step", name, strings.Join(SystemStepNames(), ", "))
+			return nil, fmt.Errorf(msg)
+		}
+	}
+
+	var selected []SystemStep
+	for _, step := range systemSteps {
+		if len(only) > 0 && !slices.Contains(only, step.Name) {
+			continue
+		}
+		if slices.Contains(skip, step.Name) {
+			continue
+		}
+		selected = append(selected, step)
+	}
+
+	return selected, nil
+}
+
+// RunSystemSteps executes the selected steps and records each completed one
+func (sc *SystemConfig) RunSystemSteps(steps []SystemStep, resume bool, content []byte) error {
+	statePath := filepath.Join(SystemVarMount, SystemStateName)
+	configHash := systemConfigHash(content)
+
+	state := SystemState{
+		ConfigHash: configHash,
+		Completed:  make(map[string]time.Time),
+	}
+	if resume {
+		previous, err := SystemLoadState(statePath)
+		if err != nil {
+			return err
+		}
+		if previous.ConfigHash != "" && previous.ConfigHash != configHash {
+			fmt.Println(T("system-resume-changed"))
+		} else {
+			state.Completed = previous.Completed
+		}
+	}
+
+	for index, step := range steps {
+		if done, ok := state.Completed[step.Name]; ok {
+			fmt.Println(Tf("system-step-resumed", step.Name, done.Format("2006-01-02 15:04:05")))
+			continue
+		}
+
+		fmt.Println(Tf("system-step-start", index+1, len(steps), step.Name, step.Method))
+		if err := step.Run(sc); err != nil {
+			return fmt.Errorf(Tf("system-step-failed", step.Name, err))
+		}
+
+		if sc.DryRun {
+			continue
+		}
+		state.Completed[step.Name] = time.Now()
+
+		// rewritten after every step, so it follows /var/gd-tools once mounted
+		if err := state.Save(statePath); err != nil {
+			return err
+		}
+	}
+
+	if sc.DryRun {
+		return nil
+	}
+
+	for _, step := range systemSteps {
+		if _, ok := state.Completed[step.Name]; !ok {
+			return nil // keep the state for a later --resume
+		}
+	}
+
+	return os.Remove(statePath)
+}
+
+func SystemLoadState(path string) (*SystemState, error) {
+	state := SystemState{
+		Completed: make(map[string]time.Time),
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &state, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(content, &state); err != nil {
+		return nil, err
+	}
+	if state.Completed == nil {
+		state.Completed = make(map[string]time.Time)
+	}
+
+	return &state, nil
+}
+
+func (st SystemState) Save(path string) error {
+	content, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, content, 0600)
+}
+
+func systemConfigHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
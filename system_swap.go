@@ -0,0 +1,285 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	SwapSysctlConf  = "/etc/sysctl.d/60-gd-tools-swap.conf"
+	SwapZramDefault = "/etc/default/zramswap"
+	SwapFstabRE     = `^/swap\.img\s+none\s+swap\s`
+
+	SwapSwappinessDefault = 60 // of the kernel, restored when the setting is removed
+)
+
+// SwapConfig tunes the kernel and adds compressed swap in RAM (zram-tools)
+type SwapConfig struct {
+	Swappiness    int    `json:"swappiness"`     // vm.swappiness 1..100 (0 keeps the kernel default)
+	Zram          bool   `json:"zram"`           // compressed swap for small VPS hosts
+	ZramPercent   int    `json:"zram_percent"`   // share of the RAM (default 50)
+	ZramAlgorithm string `json:"zram_algorithm"` // e.g. "zstd" (default) or "lz4"
+}
+
+func (sc *SystemConfig) AddSwapSpace() error {
+	if err := sc.resizeSwapFile(); err != nil {
+		return err
+	}
+
+	// without a swap section the settings of an earlier run are removed
+	swap := sc.swapConfig()
+	if err := swap.Validate(); err != nil {
+		return err
+	}
+	if err := swap.setSwappiness(sc.DryRun); err != nil {
+		return err
+	}
+
+	return swap.setupZram(sc.DryRun)
+}
+
+func (sc *SystemConfig) swapConfig() *SwapConfig {
+	if sc.Swap == nil {
+		return &SwapConfig{}
+	}
+	return sc.Swap
+}
+
+// resizeSwapFile creates, resizes or removes /swap.img to match SwapSpace
+func (sc *SystemConfig) resizeSwapFile() error {
+	swapFile := SystemSwapFile
+	wanted := int64(sc.SwapSpace) << 30
+
+	var current int64
+	if info, err := os.Stat(swapFile); err == nil {
+		current = info.Size()
+	}
+
+	if sc.SwapSpace <= 0 && current == 0 {
+		fmt.Println(T("system-swapfile-zero"))
+		return nil
+	}
+
+	active, err := swapIsActive(swapFile)
+	if err != nil {
+		return err
+	}
+
+	if current == wanted {
+		msg := Tf("system-swapfile-exist", swapFile)
+		fmt.Println(msg)
+		if !active {
+			return ShellCmd(sc.DryRun, "swapon "+swapFile)
+		}
+		return nil
+	}
+
+	// swapoff moves the pages back into RAM and fails if there is not enough
+	if active {
+		if err := ShellCmd(sc.DryRun, "swapoff "+swapFile); err != nil {
+			return fmt.Errorf(Tf("swap-err-swapoff", swapFile, err))
+		}
+	}
+
+	if sc.SwapSpace <= 0 {
+		fmt.Println(Tf("swap-file-remove", swapFile))
+		if err := ShellCmd(sc.DryRun, "rm -f "+swapFile); err != nil {
+			return err
+		}
+		if sc.DryRun {
+			return nil
+		}
+		_, err := FileRemoveLine("/etc/fstab", SwapFstabRE)
+		return err
+	}
+
+	if current > 0 {
+		fmt.Println(Tf("swap-file-resize", swapFile, current>>30, sc.SwapSpace))
+	}
+
+	cmds := []string{
+		fmt.Sprintf("rm -f %s", swapFile),
+		fmt.Sprintf("fallocate -l %dG %s", sc.SwapSpace, swapFile),
+		fmt.Sprintf("chmod 600 %s", swapFile),
+		fmt.Sprintf("mkswap %s", swapFile),
+		fmt.Sprintf("swapon %s", swapFile),
+	}
+
+	if err := ShellCmds(sc.DryRun, cmds); err != nil {
+		return err
+	}
+
+	if sc.DryRun {
+		msg := Tf("system-swapfile-fstab", swapFile)
+		fmt.Println(msg)
+		return nil
+	}
+
+	return FileAddLine("/etc/fstab", SwapFstabRE, swapFile+" none swap sw 0 0")
+}
+
+func (swap *SwapConfig) Validate() error {
+	if swap.Swappiness < 0 || swap.Swappiness > 100 {
+		return fmt.Errorf(Tf("swap-err-swappiness", swap.Swappiness))
+	}
+	if swap.ZramPercent < 0 || swap.ZramPercent > 100 {
+		return fmt.Errorf(Tf("swap-err-zram-percent", swap.ZramPercent))
+	}
+
+	return nil
+}
+
+// renderSwappiness returns the sysctl drop-in, nil if the kernel default is kept
+func (swap *SwapConfig) renderSwappiness() []byte {
+	if swap.Swappiness == 0 {
+		return nil
+	}
+	return []byte(fmt.Sprintf("# managed by gd-tools\nvm.swappiness = %d\n", swap.Swappiness))
+}
+
+func (swap *SwapConfig) setSwappiness(dryRun bool) error {
+	changed, err := kernelWriteDropIn(dryRun, SwapSysctlConf, swap.renderSwappiness())
+	if err != nil {
+		return err
+	}
+	if !changed {
+		if swap.Swappiness > 0 {
+			fmt.Println(Tf("swap-swappiness-okay", swap.Swappiness))
+		}
+		return nil
+	}
+
+	if swap.Swappiness == 0 {
+		// removing the file does not change the running value, another drop-in may still set one
+		fmt.Println(Tf("swap-swappiness-removed", SwapSysctlConf, SwapSwappinessDefault))
+		return ShellCmds(dryRun, []string{
+			fmt.Sprintf("sysctl -w vm.swappiness=%d", SwapSwappinessDefault),
+			"sysctl --system",
+		})
+	}
+
+	return ShellCmd(dryRun, fmt.Sprintf("sysctl -w vm.swappiness=%d", swap.Swappiness))
+}
+
+func (swap *SwapConfig) setupZram(dryRun bool) error {
+	if !swap.Zram {
+		// switch off a zram swap that was set up before
+		if err := exec.Command("dpkg", "-s", "zram-tools").Run(); err != nil {
+			return nil
+		}
+		if active, _ := ShellMatch("systemctl is-enabled zramswap", "enabled"); !active {
+			return nil
+		}
+		return ShellCmd(dryRun, "systemctl disable --now zramswap")
+	}
+
+	if err := PackagesEnsure(dryRun, []string{"zram-tools"}); err != nil {
+		return err
+	}
+
+	changed := false
+	for _, entry := range [][2]string{
+		{"ALGO", swap.zramAlgorithm()},
+		{"PERCENT", strconv.Itoa(swap.zramPercent())},
+		{"PRIORITY", "100"}, // before the swap file
+	} {
+		updated, err := FileSetVariable(dryRun, SwapZramDefault, entry[0], entry[1])
+		if err != nil {
+			return err
+		}
+		changed = changed || updated
+	}
+
+	if err := SystemService(dryRun, "zramswap"); err != nil {
+		return err
+	}
+	if changed {
+		return ShellCmd(dryRun, "systemctl restart zramswap")
+	}
+
+	return nil
+}
+
+func (swap *SwapConfig) zramPercent() int {
+	if swap.ZramPercent <= 0 {
+		return 50
+	}
+	return swap.ZramPercent
+}
+
+func (swap *SwapConfig) zramAlgorithm() string {
+	if swap.ZramAlgorithm == "" {
+		return "zstd"
+	}
+	return swap.ZramAlgorithm
+}
+
+func (sc *SystemConfig) CheckSwapSpace() []SystemCheck {
+	expected := fmt.Sprintf("%dG", max(sc.SwapSpace, 0))
+
+	actual := "0G"
+	if info, err := os.Stat(SystemSwapFile); err == nil {
+		actual = fmt.Sprintf("%dG", info.Size()>>30)
+	}
+	results := []SystemCheck{checkCompare(SystemSwapFile, expected, actual)}
+
+	active, err := swapIsActive(SystemSwapFile)
+	if err != nil {
+		results = append(results, checkFailed("swapon", "", err))
+	} else {
+		results = append(results, checkCompare("swapon", checkPresent(sc.SwapSpace > 0), checkPresent(active)))
+	}
+
+	found, err := checkFileMatch("/etc/fstab", SystemSwapFile)
+	if err != nil {
+		results = append(results, checkFailed("/etc/fstab", checkPresent(sc.SwapSpace > 0), err))
+	} else {
+		results = append(results, checkCompare("/etc/fstab", checkPresent(sc.SwapSpace > 0), checkPresent(found)))
+	}
+
+	// a removed setting must not leave its drop-in behind
+	swap := sc.swapConfig()
+	dropIn, _ := os.ReadFile(SwapSysctlConf)
+	results = append(results, checkCompare(filepath.Base(SwapSysctlConf),
+		checkContent(swap.renderSwappiness()), checkContent(dropIn)))
+
+	if swap.Swappiness > 0 {
+		content, err := os.ReadFile("/proc/sys/vm/swappiness")
+		if err != nil {
+			results = append(results, checkFailed("vm.swappiness", strconv.Itoa(swap.Swappiness), err))
+		} else {
+			results = append(results, checkCompare("vm.swappiness",
+				strconv.Itoa(swap.Swappiness), strings.TrimSpace(string(content))))
+		}
+	}
+
+	zram, err := swapIsActive("/dev/zram0")
+	if err != nil {
+		results = append(results, checkFailed("zram", "", err))
+	} else {
+		results = append(results, checkCompare("zram", checkPresent(swap.Zram), checkPresent(zram)))
+	}
+
+	return results
+}
+
+// swapIsActive looks for the swap device or file in /proc/swaps
+func swapIsActive(path string) (bool, error) {
+	content, err := os.ReadFile("/proc/swaps")
+	if err != nil {
+		return false, err
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[0] == path {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+const (
+	TimeSyncdDropIn  = "/etc/systemd/timesyncd.conf.d/gd-tools.conf"
+	TimeChronySource = "/etc/chrony/sources.d/gd-tools.sources"
+	LocaleGenFile    = "/etc/locale.gen"
+	LocaleDefault    = "/etc/default/locale"
+)
+
+// TimeConfig selects the NTP client and its servers
+type TimeConfig struct {
+	Daemon      string   `json:"daemon"`       // "timesyncd" (default) or "chrony"
+	Servers     []string `json:"servers"`      // e.g. "ntp1.hetzner.de" (empty keeps the distro pool)
+	Fallback    []string `json:"fallback"`     // FallbackNTP, timesyncd only
+	WaitSeconds int      `json:"wait_seconds"` // wait for the first sync (default 30)
+}
+
+// LocaleConfig lists the locales to generate and the system default
+type LocaleConfig struct {
+	Generate []string `json:"generate"` // e.g. "de_DE.UTF-8", "en_US.UTF-8"
+	Default  string   `json:"default"`  // LANG in /etc/default/locale, e.g. "C.UTF-8"
+}
+
+var localeNameRE = regexp.MustCompile(`^[a-z]{2,3}_[A-Z]{2}\.[A-Za-z0-9-]+(@[a-z]+)?$`)
+
+func DefaultTimeConfig() *TimeConfig {
+	return &TimeConfig{
+		Daemon:      "timesyncd",
+		Servers:     []string{},
+		Fallback:    []string{},
+		WaitSeconds: 30,
+	}
+}
+
+func DefaultLocaleConfig() *LocaleConfig {
+	return &LocaleConfig{
+		Generate: []string{"de_DE.UTF-8", "en_US.UTF-8"},
+		Default:  "C.UTF-8",
+	}
+}
+
+func (tc *TimeConfig) Validate() error {
+	switch tc.daemon() {
+	case "timesyncd", "chrony":
+	default:
+		return fmt.Errorf(Tf("time-err-daemon", tc.Daemon))
+	}
+	servers := append([]string{}, tc.Servers...)
+	for _, server := range append(servers, tc.Fallback...) {
+		if server == "" || strings.ContainsAny(server, " \t") {
+			return fmt.Errorf(Tf("time-err-server", server))
+		}
+	}
+
+	return nil
+}
+
+func (tc *TimeConfig) daemon() string {
+	if tc.Daemon == "" {
+		return "timesyncd"
+	}
+	return tc.Daemon
+}
+
+func (tc *TimeConfig) service() string {
+	if tc.daemon() == "chrony" {
+		return "chrony"
+	}
+	return "systemd-timesyncd"
+}
+
+// Render returns the path and content of the drop-in for the selected daemon
+func (tc *TimeConfig) Render() (string, []byte) {
+	var b strings.Builder
+	b.WriteString("# managed by gd-tools, see gd-tools-system.json\n")
+
+	if tc.daemon() == "chrony" {
+		for _, server := range tc.Servers {
+			fmt.Fprintf(&b, "server %s iburst\n", server)
+		}
+		return TimeChronySource, []byte(b.String())
+	}
+
+	b.WriteString("[Time]\n")
+	if len(tc.Servers) > 0 {
+		fmt.Fprintf(&b, "NTP=%s\n", strings.Join(tc.Servers, " "))
+	}
+	if len(tc.Fallback) > 0 {
+		fmt.Fprintf(&b, "FallbackNTP=%s\n", strings.Join(tc.Fallback, " "))
+	}
+	return TimeSyncdDropIn, []byte(b.String())
+}
+
+func (sc *SystemConfig) SyncTime() error {
+	if sc.NTP == nil {
+		fmt.Println(T("time-not-configured"))
+		return nil
+	}
+	ntp := sc.NTP
+
+	if err := ntp.Validate(); err != nil {
+		return err
+	}
+
+	// installing chrony removes systemd-timesyncd and the other way round
+	if err := PackagesEnsure(sc.DryRun, []string{ntp.service()}); err != nil {
+		return err
+	}
+
+	path, content := ntp.Render()
+	changed, err := FileWriteIfChanged(sc.DryRun, path, content, 0644)
+	if err != nil {
+		return err
+	}
+
+	// timedatectl set-ntp only knows timesyncd and fails with "NTP not supported" without it
+	if ntp.daemon() == "chrony" {
+		if err := ShellCmd(sc.DryRun, "systemctl enable --now chrony"); err != nil {
+			return err
+		}
+	} else if err := SystemService(sc.DryRun, ntp.service()); err != nil {
+		return err
+	}
+	if changed {
+		if err := ShellCmd(sc.DryRun, "systemctl restart "+ntp.service()); err != nil {
+			return err
+		}
+	}
+	if ntp.daemon() == "timesyncd" {
+		if err := ShellCmd(sc.DryRun, "timedatectl set-ntp true"); err != nil {
+			return err
+		}
+	}
+	if sc.DryRun {
+		return nil
+	}
+
+	wait := ntp.WaitSeconds
+	if wait <= 0 {
+		wait = 30
+	}
+	for elapsed := 0; ; elapsed++ {
+		if timeSynchronized() == "yes" {
+			fmt.Println(T("time-synchronized"))
+			return nil
+		}
+		if elapsed >= wait {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+
+	// not fatal, the check shows it until the clock is in sync
+	fmt.Println(Tf("time-not-synchronized", wait))
+	return nil
+}
+
+func (sc *SystemConfig) CheckTime() []SystemCheck {
+	if sc.NTP == nil {
+		return nil
+	}
+	ntp := sc.NTP
+
+	path, content := ntp.Render()
+	actual, _ := os.ReadFile(path)
+	results := []SystemCheck{checkCompare(filepath.Base(path), checkContent(content), checkContent(actual))}
+
+	state, _ := ShellOutput("systemctl is-active " + ntp.service())
+	results = append(results, checkCompare(ntp.service(), "active", state))
+	results = append(results, checkCompare("NTPSynchronized", "yes", timeSynchronized()))
+
+	return results
+}
+
+// timeSynchronized reads NTPSynchronized from "timedatectl show"
+func timeSynchronized() string {
+	output, err := ShellOutput("timedatectl show")
+	if err != nil {
+		return err.Error()
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		if value, ok := strings.CutPrefix(line, "NTPSynchronized="); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+func (lc *LocaleConfig) Validate() error {
+	for _, name := range lc.Generate {
+		if !localeNameRE.MatchString(name) {
+			return fmt.Errorf(Tf("locale-err-name", name))
+		}
+	}
+
+	if lc.Default != "" && lc.Default != "C.UTF-8" && lc.Default != "POSIX" &&
+		!slices.Contains(lc.Generate, lc.Default) {
+		return fmt.Errorf(Tf("locale-err-default", lc.Default))
+	}
+
+	return nil
+}
+
+func (sc *SystemConfig) SetLocale() error {
+	if sc.Locale == nil {
+		fmt.Println(T("locale-not-configured"))
+		return nil
+	}
+	locale := sc.Locale
+
+	if err := locale.Validate(); err != nil {
+		return err
+	}
+	if err := PackagesEnsure(sc.DryRun, []string{"locales"}); err != nil {
+		return err
+	}
+
+	missing := localeMissing(locale.Generate)
+	if len(missing) > 0 {
+		for _, name := range missing {
+			if sc.DryRun {
+				fmt.Println(Tf("exec-dry-running", LocaleGenFile+": "+localeGenLine(name)))
+				continue
+			}
+			if err := localeEnable(name); err != nil {
+				return err
+			}
+		}
+		if err := ShellCmd(sc.DryRun, "locale-gen"); err != nil {
+			return err
+		}
+	} else {
+		fmt.Println(Tf("locale-okay", strings.Join(locale.Generate, ", ")))
+	}
+
+	if locale.Default == "" {
+		return nil
+	}
+	current, _ := FileGetVariable(LocaleDefault, "LANG")
+	if current == locale.Default {
+		fmt.Println(Tf("locale-default-okay", locale.Default))
+		return nil
+	}
+
+	fmt.Println(Tf("locale-default-update", locale.Default))
+	if !sc.DryRun {
+		if err := FileBackup(LocaleDefault); err != nil {
+			return err
+		}
+	}
+	return ShellCmd(sc.DryRun, "update-locale LANG="+locale.Default)
+}
+
+func (sc *SystemConfig) CheckLocale() []SystemCheck {
+	if sc.Locale == nil {
+		return nil
+	}
+	locale := sc.Locale
+
+	missing := localeMissing(locale.Generate)
+	var results []SystemCheck
+	for _, name := range locale.Generate {
+		results = append(results, checkCompare(name,
+			checkPresent(true), checkPresent(!slices.Contains(missing, name))))
+	}
+
+	if locale.Default != "" {
+		current, err := FileGetVariable(LocaleDefault, "LANG")
+		if err != nil {
+			results = append(results, checkFailed("LANG", locale.Default, err))
+		} else {
+			results = append(results, checkCompare("LANG", locale.Default, current))
+		}
+	}
+
+	return results
+}
+
+// localeMissing compares with "locale -a", which lists de_DE.UTF-8 as de_DE.utf8
+func localeMissing(names []string) []string {
+	output, _ := ShellOutput("locale -a")
+	available := strings.Fields(output)
+
+	var missing []string
+	for _, name := range names {
+		if !slices.Contains(available, localeNormalize(name)) {
+			missing = append(missing, name)
+		}
+	}
+
+	return missing
+}
+
+func localeNormalize(name string) string {
+	base, charset, ok := strings.Cut(name, ".")
+	if !ok {
+		return name
+	}
+	charset, modifier, hasModifier := strings.Cut(charset, "@")
+	charset = strings.ToLower(strings.ReplaceAll(charset, "-", ""))
+	if hasModifier {
+		return base + "." + charset + "@" + modifier
+	}
+	return base + "." + charset
+}
+
+// localeGenLine turns "de_DE.UTF-8" into "de_DE.UTF-8 UTF-8"
+func localeGenLine(name string) string {
+	_, charset, _ := strings.Cut(name, ".")
+	charset, _, _ = strings.Cut(charset, "@")
+	return name + " " + charset
+}
+
+// localeEnable uncomments the line in locale.gen or adds it
+func localeEnable(name string) error {
+	line := localeGenLine(name)
+	pattern := `^#?\s*` + regexp.QuoteMeta(line) + `\s*$`
+
+	changed, err := FileReplaceLine(LocaleGenFile, pattern, line)
+	if err != nil || changed {
+		return err
+	}
+
+	return FileAddLine(LocaleGenFile, `^`+regexp.QuoteMeta(line)+`\s*$`, line)
+}
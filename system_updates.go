@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	UpdatesUnattendedConf = "/etc/apt/apt.conf.d/52gd-tools-unattended-upgrades"
+	UpdatesPeriodicConf   = "/etc/apt/apt.conf.d/20auto-upgrades"
+	UpdatesRebootFlag     = "/var/run/reboot-required"
+
+	// the apt lists change at most with the daily apt timer
+	UpdatesCacheTime = 5 * time.Minute
+)
+
+type UpdatesConfig struct {
+	Enabled    bool     `json:"enabled"`
+	Origins    []string `json:"origins"`     // Unattended-Upgrade::Origins-Pattern
+	AutoReboot bool     `json:"auto_reboot"` // reboot if a package requires it
+	RebootTime string   `json:"reboot_time"` // e.g. "03:30"
+	Mail       string   `json:"mail"`        // report recipient (needs a local MTA)
+	MailReport string   `json:"mail_report"` // "always", "only-on-error" or "on-change"
+	Blacklist  []string `json:"blacklist"`   // never upgraded automatically (regex)
+}
+
+// UpdatesInfo is shown on the status page
+type UpdatesInfo struct {
+	Pending        int
+	Security       int
+	RebootRequired bool
+	RebootPackages []string
+	Error          string
+}
+
+var updatesTimeRE = regexp.MustCompile(`^([01][0-9]|2[0-3]):[0-5][0-9]$`)
+
+var (
+	updatesMutex sync.Mutex
+	updatesInfo  *UpdatesInfo
+	updatesTime  time.Time
+)
+
+func DefaultUpdatesConfig(sysAdmin string) *UpdatesConfig {
+	return &UpdatesConfig{
+		Enabled: true,
+		Origins: []string{
+			"origin=${distro_id},archive=${distro_codename}",
+			"origin=${distro_id},archive=${distro_codename}-security",
+			"origin=${distro_id}ESMApps,archive=${distro_codename}-apps-security",
+			"origin=${distro_id}ESM,archive=${distro_codename}-infra-security",
+		},
+		AutoReboot: false,
+		RebootTime: "03:30",
+		Mail:       sysAdmin,
+		MailReport: "only-on-error",
+		Blacklist:  []string{},
+	}
+}
+
+func (uc *UpdatesConfig) Validate() error {
+	if uc.AutoReboot && !updatesTimeRE.MatchString(uc.RebootTime) {
+		return fmt.Errorf(Tf("updates-err-reboot-time", uc.RebootTime))
+	}
+
+	switch uc.MailReport {
+	case "", "always", "only-on-error", "on-change":
+	default:
+		return fmt.Errorf(Tf("updates-err-mail-report", uc.MailReport))
+	}
+
+	return nil
+}
+
+func (uc *UpdatesConfig) Render() ([]byte, []byte, error) {
+	enabled := "0"
+	if uc.Enabled {
+		enabled = "1"
+	}
+	rebootTime := uc.RebootTime
+	if rebootTime == "" {
+		rebootTime = "now"
+	}
+	mailReport := uc.MailReport
+	if mailReport == "" {
+		mailReport = "only-on-error"
+	}
+
+	data := struct {
+		Enabled    string
+		Origins    []string
+		Blacklist  []string
+		AutoReboot bool
+		RebootTime string
+		Mail       string
+		MailReport string
+	}{
+		Enabled:    enabled,
+		Origins:    uc.Origins,
+		Blacklist:  uc.Blacklist,
+		AutoReboot: uc.AutoReboot,
+		RebootTime: rebootTime,
+		Mail:       uc.Mail,
+		MailReport: mailReport,
+	}
+
+	unattended, err := TemplateParse("unattended-upgrades.conf", data)
+	if err != nil {
+		return nil, nil, err
+	}
+	periodic, err := TemplateParse("auto-upgrades.conf", data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return unattended, periodic, nil
+}
+
+func (sc *SystemConfig) ConfigureUpdates() error {
+	if sc.Updates == nil {
+		fmt.Println(T("updates-not-configured"))
+		return nil
+	}
+
+	if err := sc.Updates.Validate(); err != nil {
+		return err
+	}
+	if err := PackagesEnsure(sc.DryRun, []string{"unattended-upgrades"}); err != nil {
+		return err
+	}
+
+	unattended, periodic, err := sc.Updates.Render()
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	for _, entry := range []struct {
+		path    string
+		content []byte
+	}{
+		{UpdatesUnattendedConf, unattended},
+		{UpdatesPeriodicConf, periodic},
+	} {
+		if !sc.DryRun {
+			if err := updatesValidate(entry.path, entry.content); err != nil {
+				return err
+			}
+		}
+		updated, err := FileWriteIfChanged(sc.DryRun, entry.path, entry.content, 0644)
+		if err != nil {
+			return err
+		}
+		changed = changed || updated
+	}
+
+	if !changed {
+		fmt.Println(T("updates-okay"))
+		return nil
+	}
+
+	return SystemService(sc.DryRun, "unattended-upgrades")
+}
+
+// updatesValidate lets apt-config parse the file before it is put in place,
+// a syntax error in apt.conf.d breaks every apt command
+func updatesValidate(path string, content []byte) error {
+	temp, err := os.CreateTemp("", filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(content); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	aptConfig, err := exec.LookPath("apt-config")
+	if err != nil {
+		return nil
+	}
+	cmd := exec.Command(aptConfig, "-c", temp.Name(), "dump")
+	cmd.Env = append(os.Environ(), "LANG=C")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf(Tf("updates-err-apt-config", strings.TrimSpace(string(out))))
+	}
+
+	return nil
+}
+
+func (sc *SystemConfig) CheckUpdates() []SystemCheck {
+	if sc.Updates == nil {
+		return nil
+	}
+
+	unattended, periodic, err := sc.Updates.Render()
+	if err != nil {
+		return []SystemCheck{checkFailed("unattended-upgrades", "", err)}
+	}
+
+	var results []SystemCheck
+	for _, entry := range []struct {
+		path    string
+		content []byte
+	}{
+		{UpdatesUnattendedConf, unattended},
+		{UpdatesPeriodicConf, periodic},
+	} {
+		actual, _ := os.ReadFile(entry.path)
+		results = append(results, checkCompare(filepath.Base(entry.path),
+			checkContent(entry.content), checkContent(actual)))
+	}
+
+	return results
+}
+
+// UpdatesGetInfo returns the cached info, apt-check takes seconds and must not run per page load
+func UpdatesGetInfo() UpdatesInfo {
+	updatesMutex.Lock()
+	defer updatesMutex.Unlock()
+
+	if updatesInfo == nil || time.Since(updatesTime) > UpdatesCacheTime {
+		info := updatesCollect()
+		updatesInfo = &info
+		updatesTime = time.Now()
+	}
+	return *updatesInfo
+}
+
+// updatesCollect reads pending updates and the reboot flag, works without root
+func updatesCollect() UpdatesInfo {
+	var info UpdatesInfo
+
+	if _, err := os.Stat(UpdatesRebootFlag); err == nil {
+		info.RebootRequired = true
+		if content, err := os.ReadFile(UpdatesRebootFlag + ".pkgs"); err == nil {
+			info.RebootPackages = strings.Fields(string(content))
+		}
+	}
+
+	// apt-check prints "<updates>;<security>" on stderr
+	cmd := exec.Command("/usr/lib/update-notifier/apt-check")
+	cmd.Env = append(os.Environ(), "LANG=C")
+	if out, err := cmd.CombinedOutput(); err == nil {
+		parts := strings.SplitN(strings.TrimSpace(string(out)), ";", 2)
+		if len(parts) == 2 {
+			info.Pending, _ = strconv.Atoi(parts[0])
+			info.Security, _ = strconv.Atoi(parts[1])
+			return info
+		}
+	}
+
+	output, err := ShellOutput("apt list --upgradable")
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	for _, line := range strings.Split(output, "\n") {
+		if strings.Contains(line, "[upgradable from:") {
+			info.Pending++
+			if strings.Contains(line, "-security") {
+				info.Security++
+			}
+		}
+	}
+
+	return info
+}
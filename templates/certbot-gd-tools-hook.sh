@@ -0,0 +1,13 @@
+#!/bin/sh
+# Managed by gd-tools, runs after every certbot renewal.
+# serve runs as gd-tools and reads the certificates for /metrics, /healthz
+# and /api/v1/certificates. certbot creates live/ and archive/ with 0700,
+# so the directories get group gd-tools. The private keys stay 0600 root.
+set -e
+
+for dir in /etc/letsencrypt/live /etc/letsencrypt/archive; do
+	[ -d "$dir" ] || continue
+	chgrp gd-tools "$dir"
+	chmod 0750 "$dir"
+	find "$dir" -mindepth 1 -maxdepth 1 -type d -exec chgrp gd-tools {} + -exec chmod 0750 {} +
+done
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// on the root filesystem, fstab backups must not hide under the mount they repair
+	BackupRoot     = "/var/backups/gd-tools"
+	BackupManifest = "gd-tools-backup.json"
+	BackupFormat   = "20060102-150405"
+)
+
+// BackupEntry is one file saved before gd-tools changed it
+type BackupEntry struct {
+	Path    string      `json:"path"`
+	Existed bool        `json:"existed"` // false: restore removes the file
+	Mode    os.FileMode `json:"mode"`
+	UID     int         `json:"uid"`
+	GID     int         `json:"gid"`
+}
+
+// BackupSet is the backup directory of one gd-tools run
+type BackupSet struct {
+	Stamp   string        `json:"stamp"`
+	Entries []BackupEntry `json:"entries"`
+}
+
+// all files of one run go into the same directory, each file only once
+var backupCurrent *BackupSet
+
+func backupDir(stamp string) string {
+	return filepath.Join(BackupRoot, stamp)
+}
+
+// FileBackup saves path (or the fact that it is missing) before it gets changed
+func FileBackup(path string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	if backupCurrent == nil {
+		backupCurrent = &BackupSet{Stamp: time.Now().Format(BackupFormat)}
+	}
+	for _, entry := range backupCurrent.Entries {
+		if entry.Path == path {
+			return nil
+		}
+	}
+
+	entry := BackupEntry{Path: path}
+	info, err := os.Stat(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		entry.Existed = true
+		entry.Mode = info.Mode().Perm()
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			entry.UID, entry.GID = int(stat.Uid), int(stat.Gid)
+		}
+
+		dst := filepath.Join(backupDir(backupCurrent.Stamp), path)
+		if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+			return err
+		}
+		if err := FileCopy(path, dst, int(entry.Mode)); err != nil {
+			return err
+		}
+	}
+
+	backupCurrent.Entries = append(backupCurrent.Entries, entry)
+
+	return backupCurrent.save()
+}
+
+func (bs *BackupSet) save() error {
+	dir := backupDir(bs.Stamp)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	content, err := json.MarshalIndent(bs, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, BackupManifest), content, 0600)
+}
+
+// FileWriteAtomic backs up path and replaces it via a temp file in the same directory
+func FileWriteAtomic(path string, content []byte, mode os.FileMode) error {
+	// write through symlinks instead of replacing them
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+
+	if err := FileBackup(path); err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".gd-tools-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(content); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	// keep the owner of an existing file
+	if info, err := os.Stat(path); err == nil {
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			if err := os.Chown(temp.Name(), int(stat.Uid), int(stat.Gid)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := os.Chmod(temp.Name(), mode); err != nil {
+		return err
+	}
+
+	// a broken fstab can make the host unbootable
+	if path == "/etc/fstab" {
+		if err := FileVerifyFstab(temp.Name()); err != nil {
+			return err
+		}
+	}
+
+	return os.Rename(temp.Name(), path)
+}
+
+// FileRemove backs up path and removes it
+func FileRemove(dryRun bool, path string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+
+	if dryRun {
+		fmt.Println(Tf("exec-dry-running", "rm "+path))
+		return nil
+	}
+
+	if err := FileBackup(path); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// FileVerifyFstab lets findmnt check a fstab file before it is used
+func FileVerifyFstab(path string) error {
+	findmnt, err := exec.LookPath("findmnt")
+	if err != nil {
+		return nil
+	}
+
+	cmd := exec.Command(findmnt, "--verify", "--tab-file", path)
+	cmd.Env = append(os.Environ(), "LANG=C")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf(Tf("backup-err-fstab", strings.TrimSpace(string(out))))
+	}
+
+	return nil
+}
+
+// BackupList returns all backup sets, the newest first
+func BackupList() ([]BackupSet, error) {
+	dirs, err := os.ReadDir(BackupRoot)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var sets []BackupSet
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		set, err := BackupLoad(dir.Name())
+		if err != nil {
+			continue
+		}
+		sets = append(sets, *set)
+	}
+	sort.Slice(sets, func(i, j int) bool {
+		return sets[i].Stamp > sets[j].Stamp
+	})
+
+	return sets, nil
+}
+
+func BackupLoad(stamp string) (*BackupSet, error) {
+	if _, err := time.Parse(BackupFormat, stamp); err != nil {
+		return nil, fmt.Errorf(Tf("backup-err-stamp", stamp))
+	}
+
+	content, err := os.ReadFile(filepath.Join(backupDir(stamp), BackupManifest))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf(Tf("backup-err-stamp", stamp))
+		}
+		return nil, err
+	}
+
+	var set BackupSet
+	if err := json.Unmarshal(content, &set); err != nil {
+		return nil, err
+	}
+
+	return &set, nil
+}
+
+// Restore puts the files of a backup set back. The current files go into a new backup.
+func (bs *BackupSet) Restore(dryRun bool) error {
+	var problems []error
+	fstab := false
+
+	for _, entry := range bs.Entries {
+		if !entry.Existed {
+			fmt.Println(Tf("backup-restore-remove", entry.Path))
+			if err := FileRemove(dryRun, entry.Path); err != nil {
+				problems = append(problems, err)
+			}
+			continue
+		}
+
+		fmt.Println(Tf("backup-restore-file", entry.Path))
+		if dryRun {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(backupDir(bs.Stamp), entry.Path))
+		if err != nil {
+			problems = append(problems, err)
+			continue
+		}
+		if err := FileWriteAtomic(entry.Path, content, entry.Mode); err != nil {
+			problems = append(problems, fmt.Errorf("%s: %w", entry.Path, err))
+			continue
+		}
+		if err := os.Chown(entry.Path, entry.UID, entry.GID); err != nil {
+			problems = append(problems, err)
+		}
+		if entry.Path == "/etc/fstab" {
+			fstab = true
+		}
+	}
+
+	if fstab {
+		if err := ShellCmd(dryRun, "systemctl daemon-reload"); err != nil {
+			problems = append(problems, err)
+		}
+	}
+
+	return errors.Join(problems...)
+}
@@ -1,7 +1,8 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
+	"fmt"
 	"io"
 	"io/fs"
 	"os"
@@ -44,34 +45,165 @@ func FileAddLine(path, pattern, text string) error {
 		return err
 	}
 
-	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
-	if err != nil {
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	found := false
 	var lines []string
-	for scanner.Scan() {
-		line := scanner.Text()
-		lines = append(lines, line)
+	if len(content) > 0 {
+		lines = strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	}
+	for _, line := range lines {
 		if re.MatchString(line) {
-			found = true
+			return nil
 		}
 	}
-	if err := scanner.Err(); err != nil {
-		return err
-	}
-	if found {
-		return nil
-	}
 
 	lines = append(lines, text)
 	result := strings.Join(lines, "\n")
 
-	if err := os.WriteFile(path, []byte(result+"\n"), 0644); err != nil {
-		return err
+	return FileWriteAtomic(path, []byte(result+"\n"), 0644)
+}
+
+// FileRemoveLine removes all lines matching pattern and reports a change
+func FileRemoveLine(path, pattern string) (bool, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return false, err
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+
+	removed := false
+	var lines []string
+	for _, line := range strings.Split(strings.TrimRight(string(content), "\n"), "\n") {
+		if re.MatchString(line) {
+			removed = true
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if !removed {
+		return false, nil
+	}
+
+	return true, FileWriteAtomic(path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+// FileReplaceLine replaces all lines matching pattern with text and reports a change
+func FileReplaceLine(path, pattern, text string) (bool, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return false, err
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	changed := false
+	lines := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	for i, line := range lines {
+		if re.MatchString(line) && line != text {
+			lines[i] = text
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+
+	return true, FileWriteAtomic(path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+// FileReplace replaces every occurrence of old in path and reports a change
+func FileReplace(path, old, new string) (bool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	if !strings.Contains(string(content), old) {
+		return false, nil
+	}
+
+	result := strings.ReplaceAll(string(content), old, new)
+
+	return true, FileWriteAtomic(path, []byte(result), 0644)
+}
+
+// FileGetVariable reads KEY=value from a shell style config file
+func FileGetVariable(path, key string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(line), key+"="); ok {
+			return strings.Trim(value, `"'`), nil
+		}
+	}
+
+	return "", nil
+}
+
+// FileSetVariable sets KEY=value in a shell style config file and reports a change
+func FileSetVariable(dryRun bool, path, key, value string) (bool, error) {
+	current, err := FileGetVariable(path, key)
+	if err != nil {
+		return false, err
 	}
-	return nil
+	if current == value {
+		return false, nil
+	}
+
+	if dryRun {
+		fmt.Println(Tf("exec-dry-running", fmt.Sprintf("%s: %s=%s", path, key, value)))
+		return true, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+
+	found := false
+	lines := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), key+"=") {
+			lines[i] = key + "=" + value
+			found = true
+		}
+	}
+	if !found {
+		lines = append(lines, key+"="+value)
+	}
+
+	return true, FileWriteAtomic(path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+// FileWriteIfChanged writes content only if it differs and reports a change
+func FileWriteIfChanged(dryRun bool, path string, content []byte, mode os.FileMode) (bool, error) {
+	current, err := os.ReadFile(path)
+	if err == nil && bytes.Equal(current, content) {
+		return false, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	if dryRun {
+		fmt.Println(Tf("exec-dry-running", "write "+path))
+		return true, nil
+	}
+
+	return true, FileWriteAtomic(path, content, mode)
 }
@@ -62,6 +62,22 @@ func ShellMatch(cmdStr string, pattern string) (bool, error) {
 	return matched, nil
 }
 
+// ShellOutput runs a command without side effects and returns its output
+func ShellOutput(cmdStr string) (string, error) {
+	cmd, err := shellPrepare(cmdStr)
+	if err != nil {
+		return "", err
+	}
+
+	cmd.Env = append(os.Environ(), "LANG=C")
+	out, err := cmd.Output()
+	if err != nil {
+		return strings.TrimSpace(string(out)), err
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
 func ShellEditor(path string) error {
 	editor := os.Getenv("EDITOR")
 	if editor == "" {
@@ -87,6 +103,20 @@ func SystemService(dryRun bool, serviceName string) error {
 	return ShellCmd(dryRun, cmd)
 }
 
+// PackagesEnsure installs the packages that are missing
+func PackagesEnsure(dryRun bool, packages []string) error {
+	for _, pkgName := range packages {
+		if err := exec.Command("dpkg", "-s", pkgName).Run(); err == nil {
+			continue
+		}
+		if err := ShellCmd(dryRun, "apt install -y "+pkgName); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func shellPrepare(cmdStr string) (*exec.Cmd, error) {
 	if cmdStr == "" {
 		return nil, fmt.Errorf(T("exec-err-missing"))